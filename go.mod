@@ -1,12 +1,13 @@
 module github.com/ParaServices/pgmngr
 
-go 1.15
+go 1.16
 
 require (
 	github.com/ParaServices/errgo v0.3.0
 	github.com/corpix/uarand v0.1.1 // indirect
 	github.com/gookit/color v1.2.0
 	github.com/icrowley/fake v0.0.0-20180203215853-4178557ae428
+	github.com/lib/pq v1.2.0
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/stretchr/testify v1.4.0
 	github.com/urfave/cli v1.22.0
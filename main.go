@@ -2,10 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/ParaServices/errgo"
 	"github.com/ParaServices/pgmngr/pgmngr"
@@ -47,6 +55,207 @@ func displayErrorOrMessage(err error) error {
 	return nil
 }
 
+// withMigrator runs fn with a Migrator for the loaded config, cancelling its
+// context on SIGINT or SIGTERM.
+func withMigrator(config *pgmngr.Config, fn func(ctx context.Context, m *pgmngr.Migrator) error) error {
+	m, err := pgmngr.NewMigrator(config)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	m.OnEvent = pgmngr.PrintEvent
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return fn(ctx, m)
+}
+
+func printPlan(config *pgmngr.Config, plan *pgmngr.Plan, summary bool) error {
+	if plan.Empty() {
+		color.Info.Tips("No pending migrations")
+		return nil
+	}
+
+	src := pgmngr.NewDirectorySource(config.Migration.Directory)
+	for _, pm := range append(plan.Migrations, plan.Repeatable...) {
+		notes := ""
+		if !pm.Transaction {
+			notes += color.Warn.Sprint(" (runs outside a transaction)")
+		}
+		if pm.OutOfOrder {
+			notes += color.Warn.Sprint(" (out of order)")
+		}
+		if pm.Repeatable {
+			notes += color.Note.Sprint(" (repeatable)")
+		}
+		color.Note.Tips("Pending migration: %s%s", color.FgBlue.Render(pm.File), notes)
+		if summary {
+			continue
+		}
+
+		b, err := src.ReadFile(pm.File)
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.TrimRight(string(b), "\n"))
+		fmt.Println()
+	}
+	return nil
+}
+
+// writeSchemaFile writes the schema of the database to the file, nothing is
+// written when no file is given.
+func writeSchemaFile(ctx context.Context, m *pgmngr.Migrator, filePath string) error {
+	if filePath == "" {
+		return nil
+	}
+
+	dump, err := m.DumpSchema(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filePath, []byte(dump), 0644)
+	if err != nil {
+		return pgmngr.NewError(err)
+	}
+	color.Info.Tips("Schema written to: %s", color.FgBlue.Render(filePath))
+	return nil
+}
+
+func printMigrationRecord(record *pgmngr.MigrationRecord, format string) error {
+	switch format {
+	case "json":
+		b, err := json.Marshal(record)
+		if err != nil {
+			return pgmngr.NewError(err)
+		}
+		return prettyPrintJSON(b)
+	case "text":
+		transaction := ""
+		if record.Transaction != nil {
+			transaction = strconv.FormatBool(*record.Transaction)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "version:\t%v\n", record.Version)
+		fmt.Fprintf(w, "name:\t%s\n", record.Name)
+		fmt.Fprintf(w, "file name:\t%s\n", record.FileName)
+		fmt.Fprintf(w, "checksum:\t%s\n", record.Checksum)
+		fmt.Fprintf(w, "applied at:\t%s\n", record.AppliedAt.Format(time.RFC3339))
+		fmt.Fprintf(w, "duration (ms):\t%v\n", record.DurationMS)
+		fmt.Fprintf(w, "applied by:\t%s\n", record.AppliedBy)
+		fmt.Fprintf(w, "hostname:\t%s\n", record.Hostname)
+		fmt.Fprintf(w, "tool version:\t%s\n", record.ToolVersion)
+		fmt.Fprintf(w, "in transaction:\t%s\n", transaction)
+		return w.Flush()
+	default:
+		return errgo.New(fmt.Errorf("unknown format: %s, expected text or json", format))
+	}
+}
+
+func printMigrationStatus(statuses pgmngr.MigrationStatuses, format string) error {
+	switch format {
+	case "json":
+		b, err := json.Marshal(statuses)
+		if err != nil {
+			return pgmngr.NewError(err)
+		}
+		return prettyPrintJSON(b)
+	case "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tFILE\tAPPLIED AT\tSTATE")
+		for i := range statuses {
+			appliedAt := ""
+			if statuses[i].AppliedAt != nil {
+				appliedAt = statuses[i].AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(
+				w,
+				"%v\t%s\t%s\t%s\t%s\n",
+				statuses[i].Version,
+				statuses[i].Name,
+				statuses[i].File,
+				appliedAt,
+				statuses[i].State,
+			)
+		}
+		return w.Flush()
+	default:
+		return errgo.New(fmt.Errorf("unknown format: %s, expected text or json", format))
+	}
+}
+
+func printLintFindings(findings pgmngr.LintFindings, format string) error {
+	switch format {
+	case "json":
+		b, err := json.Marshal(findings)
+		if err != nil {
+			return pgmngr.NewError(err)
+		}
+		return prettyPrintJSON(b)
+	case "sarif":
+		b, err := findings.SARIF()
+		if err != nil {
+			return err
+		}
+		return prettyPrintJSON(b)
+	case "text":
+		for i := range findings {
+			location := findings[i].File
+			if findings[i].Line > 0 {
+				location = fmt.Sprintf("%s:%v", location, findings[i].Line)
+			}
+			severity := color.Warn.Sprint(findings[i].Severity)
+			if findings[i].Severity == pgmngr.LintError {
+				severity = color.Error.Sprint(findings[i].Severity)
+			}
+			fmt.Printf("%s: %s: %s (%s)\n", location, severity, findings[i].Message, findings[i].Rule)
+		}
+		return nil
+	default:
+		return errgo.New(fmt.Errorf("unknown format: %s, expected text, json or sarif", format))
+	}
+}
+
+func printSchemaDrift(drifts []pgmngr.SchemaDrift, format string) error {
+	switch format {
+	case "json":
+		b, err := json.Marshal(drifts)
+		if err != nil {
+			return pgmngr.NewError(err)
+		}
+		return prettyPrintJSON(b)
+	case "text":
+		for i := range drifts {
+			switch drifts[i].Kind {
+			case pgmngr.DriftAdded:
+				color.Warn.Printf("+ %s\n", drifts[i].Object)
+			case pgmngr.DriftRemoved:
+				color.Warn.Printf("- %s\n", drifts[i].Object)
+			case pgmngr.DriftChanged:
+				color.Warn.Printf("~ %s\n", drifts[i].Object)
+				fmt.Printf("  expected:\n    %s\n", strings.Replace(drifts[i].Expected, "\n", "\n    ", -1))
+				fmt.Printf("  actual:\n    %s\n", strings.Replace(drifts[i].Actual, "\n", "\n    ", -1))
+			}
+		}
+		return nil
+	default:
+		return errgo.New(fmt.Errorf("unknown format: %s, expected text or json", format))
+	}
+}
+
 func main() {
 	app := cli.NewApp()
 
@@ -61,6 +270,21 @@ func main() {
 			EnvVar: "PGMNGR_CONFIG_FILE",
 			Usage:  "Configures the path of the config file to be used to perform DB management",
 		},
+		cli.StringFlag{
+			Name:   "env",
+			EnvVar: "PGMNGR_ENV",
+			Usage:  "Selects the environment of the config file, e.g. dev or prod, overriding the top-level values",
+		},
+		cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "Overrides a config field, e.g. --set migration.table.schema=app, or PGMNGR_MIGRATION_TABLE_SCHEMA=app",
+		},
+	}
+	for _, flag := range pgmngr.ConfigFlags {
+		app.Flags = append(app.Flags, cli.StringFlag{
+			Name:  flag.Name,
+			Usage: fmt.Sprintf("%s, overrides %s like %s", flag.Usage, flag.Field, pgmngr.ConfigEnvVar(flag.Field)),
+		})
 	}
 
 	config := &pgmngr.Config{}
@@ -96,8 +320,347 @@ func main() {
 				{
 					Name:  "forward",
 					Usage: "applies all unapplied migrations in ascending order",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "prints the migrations that would be applied without applying them",
+						},
+						cli.BoolFlag{
+							Name:  "summary",
+							Usage: "only prints the migration files, not their SQL, with --dry-run",
+						},
+						cli.StringFlag{
+							Name:  "plan-out",
+							Usage: "writes the migrations that would be applied to the given file, implies --dry-run",
+						},
+						cli.StringFlag{
+							Name:  "plan",
+							Usage: "applies the migrations only if they match the plan written by --plan-out",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.Bool("dry-run") || c.String("plan-out") != "" {
+							return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+								plan, err := m.Plan(ctx)
+								if err != nil {
+									return err
+								}
+								if c.String("plan-out") != "" {
+									err = plan.Save(c.String("plan-out"))
+									if err != nil {
+										return err
+									}
+								}
+								return printPlan(config, plan, c.Bool("summary"))
+							}))
+						}
+
+						if c.String("plan") != "" {
+							plan, err := pgmngr.LoadPlan(c.String("plan"))
+							if err != nil {
+								return displayErrorOrMessage(err)
+							}
+							return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+								_, err := m.ApplyPlan(ctx, plan)
+								if err != nil {
+									return err
+								}
+								return writeSchemaFile(ctx, m, config.Migration.SchemaFile)
+							}))
+						}
+
+						return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							_, err := m.Up(ctx)
+							if err != nil {
+								return err
+							}
+							return writeSchemaFile(ctx, m, config.Migration.SchemaFile)
+						}))
+					},
+				},
+				{
+					Name:  "rollback",
+					Usage: "reverts applied migrations in descending order",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "steps, n",
+							Value: 1,
+							Usage: "number of applied migrations to revert",
+						},
+						cli.Int64Flag{
+							Name:  "to",
+							Usage: "reverts every applied migration newer than the given version",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.IsSet("steps") && c.IsSet("to") {
+							return displayErrorOrMessage(
+								errgo.New(errors.New("only one of --steps or --to can be given")),
+							)
+						}
+
+						return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							var err error
+							if c.IsSet("to") {
+								_, err = m.DownTo(ctx, c.Int64("to"))
+							} else {
+								_, err = m.Down(ctx, c.Int("steps"))
+							}
+							return err
+						}))
+					},
+				},
+				{
+					Name:  "redo",
+					Usage: "reverts the latest applied migration and applies it again",
 					Action: func(c *cli.Context) error {
-						return displayErrorOrMessage(pgmngr.ApplyMigration(pgmngr.Forward, config))
+						return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							_, err := m.Redo(ctx)
+							return err
+						}))
+					},
+				},
+				{
+					Name:      "to",
+					Usage:     "applies or reverts migrations until exactly the given version is the latest applied",
+					ArgsUsage: "VERSION",
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) == 0 {
+							return displayErrorOrMessage(
+								errgo.New(errors.New("migration version not given, try `pgmngr migration to 1600000000`")),
+							)
+						}
+						v, err := strconv.ParseInt(c.Args()[0], 10, 64)
+						if err != nil {
+							return displayErrorOrMessage(errgo.New(err))
+						}
+
+						return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							_, err := m.To(ctx, v)
+							return err
+						}))
+					},
+				},
+				{
+					Name:  "baseline",
+					Usage: "marks every migration up to the given version as applied without running it",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:  "version",
+							Usage: "the version the database is already migrated to",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if !c.IsSet("version") {
+							return displayErrorOrMessage(
+								errgo.New(errors.New("baseline version not given, try `pgmngr migration baseline --version 1600000000`")),
+							)
+						}
+
+						return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							_, err := m.Baseline(ctx, c.Int64("version"))
+							return err
+						}))
+					},
+				},
+				{
+					Name:  "import",
+					Usage: "imports the migration history and files of another migration tool",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "from",
+							Usage: "the tool to import from: goose, golang-migrate, sql-migrate, pgmgr or rails",
+						},
+						cli.StringFlag{
+							Name:  "table",
+							Usage: "the tracking table of the tool, defaults to the default table of the tool",
+						},
+						cli.StringFlag{
+							Name:  "dir",
+							Usage: "the migration directory of the tool, defaults to the migration directory",
+						},
+						cli.StringFlag{
+							Name:  "archive",
+							Usage: "the directory the converted files are moved to, defaults to <migration directory>_archive/<tool>",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.String("from") == "" {
+							return displayErrorOrMessage(
+								errgo.New(errors.New("migration tool not given, try `pgmngr migration import --from goose`")),
+							)
+						}
+
+						return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							_, err := m.Import(ctx, pgmngr.ImportOptions{
+								From:      c.String("from"),
+								Table:     c.String("table"),
+								Directory: c.String("dir"),
+								Archive:   c.String("archive"),
+							})
+							return err
+						}))
+					},
+				},
+				{
+					Name:  "squash",
+					Usage: "replaces every migration up to the given version with a single baseline file",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:  "up-to",
+							Usage: "the newest version to squash",
+						},
+						cli.StringFlag{
+							Name:  "archive",
+							Usage: "the directory the squashed files are moved to, defaults to <migration directory>_archive/<version>",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if !c.IsSet("up-to") {
+							return displayErrorOrMessage(
+								errgo.New(errors.New("version not given, try `pgmngr migration squash --up-to 1600000000`")),
+							)
+						}
+
+						return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							result, err := m.Squash(ctx, c.Int64("up-to"), c.String("archive"))
+							if err != nil {
+								return err
+							}
+							color.Success.Tips(
+								"Squashed %v migrations into: %s",
+								len(result.Versions),
+								color.FgBlue.Render(result.File),
+							)
+							color.Info.Tips("Archived the squashed migration files in: %s", color.FgBlue.Render(result.Archive))
+							return nil
+						}))
+					},
+				},
+				{
+					Name:  "repair",
+					Usage: "re-stamps the checksums of applied migrations to accept changes made to their files",
+					Action: func(c *cli.Context) error {
+						return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							return m.Repair(ctx)
+						}))
+					},
+				},
+				{
+					Name:      "show",
+					Usage:     "displays the recorded details of an applied migration",
+					ArgsUsage: "VERSION",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "format",
+							Value: "text",
+							Usage: "output format, text or json",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) == 0 {
+							return displayErrorOrMessage(
+								errgo.New(errors.New("migration version not given, try `pgmngr migration show 1600000000`")),
+							)
+						}
+						v, err := strconv.ParseInt(c.Args()[0], 10, 64)
+						if err != nil {
+							return displayErrorOrMessage(errgo.New(err))
+						}
+
+						var record *pgmngr.MigrationRecord
+						err = withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							var err error
+							record, err = m.Show(ctx, v)
+							return err
+						})
+						if err != nil {
+							return displayErrorOrMessage(err)
+						}
+
+						return displayErrorOrMessage(printMigrationRecord(record, c.String("format")))
+					},
+				},
+				{
+					Name:      "lint",
+					Usage:     "flags operations of the pending, or given, migration files known to lock or rewrite big tables",
+					ArgsUsage: "[FILE...]",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "format",
+							Value: "text",
+							Usage: "output format: text, json or sarif",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						var findings pgmngr.LintFindings
+						var err error
+						if len(c.Args()) > 0 {
+							findings, err = pgmngr.LintFiles(config, c.Args())
+						} else {
+							err = withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+								var err error
+								findings, err = m.Lint(ctx)
+								return err
+							})
+						}
+						if err != nil {
+							return displayErrorOrMessage(err)
+						}
+
+						err = printLintFindings(findings, c.String("format"))
+						if err != nil {
+							return displayErrorOrMessage(err)
+						}
+
+						if errs := findings.Errors(); len(errs) > 0 {
+							return cli.NewExitError(
+								color.Error.Sprintf("%v lint error(s)", len(errs)),
+								1,
+							)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "status",
+					Usage: "displays the applied, pending and orphaned migrations",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "format",
+							Value: "text",
+							Usage: "output format, text or json",
+						},
+						cli.BoolFlag{
+							Name:  "fail-on-pending",
+							Usage: "exits with a non-zero status when there are pending migrations",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						var statuses pgmngr.MigrationStatuses
+						err := withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							var err error
+							statuses, err = m.Status(ctx)
+							return err
+						})
+						if err != nil {
+							return displayErrorOrMessage(err)
+						}
+
+						err = printMigrationStatus(statuses, c.String("format"))
+						if err != nil {
+							return displayErrorOrMessage(err)
+						}
+
+						if c.Bool("fail-on-pending") {
+							if pending := statuses.Pending(); len(pending) > 0 {
+								return cli.NewExitError(
+									color.Error.Sprintf("%v pending migration(s)", len(pending)),
+									1,
+								)
+							}
+						}
+						return nil
 					},
 				},
 			},
@@ -120,10 +683,139 @@ func main() {
 						return displayErrorOrMessage(pgmngr.DropDatabase(*config))
 					},
 				},
+				{
+					Name:  "dump-schema",
+					Usage: "prints the schema of the database, or writes it to a file",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "output, o",
+							Usage: "the file the schema is written to, e.g. structure.sql",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							if c.String("output") != "" {
+								return writeSchemaFile(ctx, m, c.String("output"))
+							}
+
+							dump, err := m.DumpSchema(ctx)
+							if err != nil {
+								return err
+							}
+							fmt.Print(dump)
+							return nil
+						}))
+					},
+				},
+				{
+					Name:  "check-drift",
+					Usage: "compares the schema of the database with a schema file, failing when they differ",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "against",
+							Usage: "the schema file written by dump-schema, defaults to migration.schema_file",
+						},
+						cli.StringFlag{
+							Name:  "format",
+							Value: "text",
+							Usage: "output format: text or json",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						against := c.String("against")
+						if against == "" {
+							against = config.Migration.SchemaFile
+						}
+						if against == "" {
+							return displayErrorOrMessage(
+								errgo.New(errors.New("schema file not given, try `pgmngr db check-drift --against structure.sql`")),
+							)
+						}
+
+						var drifts []pgmngr.SchemaDrift
+						err := withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							var err error
+							drifts, err = m.CheckDrift(ctx, against)
+							return err
+						})
+						if err != nil {
+							return displayErrorOrMessage(err)
+						}
+
+						err = printSchemaDrift(drifts, c.String("format"))
+						if err != nil {
+							return displayErrorOrMessage(err)
+						}
+
+						if len(drifts) > 0 {
+							return cli.NewExitError(
+								color.Error.Sprintf("%v object(s) drifted from %s", len(drifts), against),
+								1,
+							)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "diff",
+					Usage: "compares the schemas of two databases and creates a migration changing the source into the target",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "source",
+							Usage: "the URL of the database the migration applies to, defaults to the configured database",
+						},
+						cli.StringFlag{
+							Name:  "target",
+							Usage: "the URL of the database with the schema the migration results in",
+						},
+						cli.StringFlag{
+							Name:  "name",
+							Value: "schema_diff",
+							Usage: "the name of the migration",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.String("target") == "" {
+							return displayErrorOrMessage(
+								errgo.New(errors.New("target database not given, try `pgmngr db diff --target postgres://localhost/scratch`")),
+							)
+						}
+
+						return displayErrorOrMessage(pgmngr.CreateDiffMigration(
+							context.Background(), config, c.String("name"), c.String("source"), c.String("target"),
+						))
+					},
+				},
+				{
+					Name:      "load-schema",
+					Usage:     "loads a schema written by dump-schema and marks the migrations it includes as applied",
+					ArgsUsage: "FILE",
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) == 0 {
+							return displayErrorOrMessage(
+								errgo.New(errors.New("schema file not given, try `pgmngr db load-schema structure.sql`")),
+							)
+						}
+
+						return displayErrorOrMessage(withMigrator(config, func(ctx context.Context, m *pgmngr.Migrator) error {
+							_, err := m.LoadSchema(ctx, c.Args()[0])
+							return err
+						}))
+					},
+				},
 				{
 					Name:  "reset",
 					Usage: "reset the database (drops the database , create the data base and does the migration)",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "from-schema",
+							Usage: "loads the given schema file instead of replaying the migrations it includes",
+						},
+					},
 					Action: func(c *cli.Context) error {
+						if c.String("from-schema") != "" {
+							return displayErrorOrMessage(pgmngr.ResetDatabaseFromSchema(*config, c.String("from-schema")))
+						}
 						return displayErrorOrMessage(pgmngr.ResetDatabase(*config))
 					},
 				},
@@ -135,11 +827,11 @@ func main() {
 			Subcommands: []cli.Command{
 				{
 					Name:  "display",
-					Usage: "creates the database if it doesn't exist",
+					Usage: "displays the config resolved for the selected environment",
 					Action: func(c *cli.Context) error {
 						b, err := json.Marshal(config)
 						if err != nil {
-							return displayErrorOrMessage(pgmngr.CreateDatabase(*config))
+							return displayErrorOrMessage(pgmngr.NewError(err))
 						}
 						return displayErrorOrMessage(prettyPrintJSON(b))
 					},
@@ -0,0 +1,70 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Baseline adopts a database whose schema was migrated by other means. The
+// schema migrations table is created when needed and every migration up to
+// and including version is marked as applied without being run, so Up starts
+// with the migrations after it.
+func (m *Migrator) Baseline(ctx context.Context, version int64) ([]MigrationResult, error) {
+	return m.migrate(ctx, func(conn *sql.Conn) ([]MigrationResult, error) {
+		mFiles, err := m.migrationFiles(Forward)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		if _, ok := mFiles[version]; !ok {
+			return nil, NewError(fmt.Errorf("migration file for version: %v not found", version))
+		}
+
+		applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		plan := make([]PlannedMigration, 0)
+		for _, v := range pendingMigrations(mFiles, applied) {
+			if v > version {
+				break
+			}
+			pm, err := newPlannedMigration(m.source(), v, mFiles[v])
+			if err != nil {
+				return nil, NewError(err)
+			}
+			plan = append(plan, pm)
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		results := make([]MigrationResult, 0)
+		for i := range plan {
+			err = m.recordMigration(ctx, tx, plan[i], plan[i].Checksum, 0)
+			if err != nil {
+				tx.Rollback()
+				return nil, NewError(err)
+			}
+			results = append(results, MigrationResult{PlannedMigration: plan[i], Type: Forward})
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		for i := range results {
+			m.notify(MigrationEvent{
+				Kind:    EventMigrationBaselined,
+				Version: results[i].Version,
+				File:    results[i].File,
+			})
+		}
+
+		return results, nil
+	})
+}
@@ -0,0 +1,35 @@
+package pgmngr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrator_Baseline(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "baseline")
+
+	// the first two migrations would fail when run, as if the database had
+	// been migrated by hand
+	writeTestMigration(t, tempDir, 1600000001, "a", "SELECT 1/0;", "SELECT 1;")
+	writeTestMigration(t, tempDir, 1600000002, "b", "SELECT 1/0;", "SELECT 1;")
+	writeTestMigration(t, tempDir, 1600000003, "c", "CREATE TABLE public.baseline_c();", "DROP TABLE public.baseline_c;")
+
+	_, err := m.Baseline(ctx, 1600000009)
+	require.Error(t, err)
+
+	results, err := m.Baseline(ctx, 1600000002)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+
+	record, err := m.Show(ctx, 1600000002)
+	require.NoError(t, err)
+	require.Equal(t, checksum([]byte("SELECT 1/0;")), record.Checksum)
+
+	results, err = m.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(results))
+	require.Equal(t, int64(1600000003), results[0].Version)
+}
@@ -0,0 +1,176 @@
+package pgmngr
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ParaServices/errgo"
+)
+
+// checksum returns the hex encoded SHA-256 of a migration file's contents.
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func fileChecksum(src MigrationSource, filePath string) (string, error) {
+	b, err := src.ReadFile(filePath)
+	if err != nil {
+		return "", NewError(err)
+	}
+	return checksum(b), nil
+}
+
+type checksumMismatch struct {
+	Version  int64
+	File     string
+	Recorded string
+	Current  string
+}
+
+// findChecksumMismatches compares the recorded checksums of the applied
+// migrations against the current contents of their files. Migrations applied
+// before checksums were recorded, migrations whose file no longer exists and
+// Go migrations are skipped.
+func findChecksumMismatches(src MigrationSource, mFiles migrationFiles, applied []MigrationRecord) ([]checksumMismatch, error) {
+	mismatches := make([]checksumMismatch, 0)
+	for i := range applied {
+		if applied[i].Checksum == "" {
+			continue
+		}
+		filePath, ok := mFiles[applied[i].Version]
+		if !ok || isGoMigrationFile(filePath) {
+			continue
+		}
+		current, err := fileChecksum(src, filePath)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		if current != applied[i].Checksum {
+			mismatches = append(mismatches, checksumMismatch{
+				Version:  applied[i].Version,
+				File:     filePath,
+				Recorded: applied[i].Checksum,
+				Current:  current,
+			})
+		}
+	}
+
+	sort.Slice(
+		mismatches,
+		func(i, j int) bool {
+			return mismatches[i].Version < mismatches[j].Version
+		},
+	)
+
+	return mismatches, nil
+}
+
+func checksumMismatchError(mismatches []checksumMismatch) error {
+	lines := make([]string, len(mismatches))
+	for i := range mismatches {
+		lines[i] = fmt.Sprintf(
+			"version: %v file: %s recorded: %s current: %s",
+			mismatches[i].Version,
+			mismatches[i].File,
+			mismatches[i].Recorded,
+			mismatches[i].Current,
+		)
+	}
+
+	errx := errgo.New(
+		fmt.Errorf(
+			"applied migrations have been modified:\n%s\nrun `pgmngr migration repair` to accept the changes",
+			strings.Join(lines, "\n"),
+		),
+	)
+	errx.Message = "checksum mismatch for applied migrations"
+	for i := range mismatches {
+		errx.Details.Add(strconv.FormatInt(mismatches[i].Version, 10), mismatches[i].File)
+	}
+	return errx
+}
+
+// RepairMigrationChecksums re-stamps the checksum and file name of every
+// applied migration whose file exists, accepting any changes made to them.
+func RepairMigrationChecksums(cfg *Config) error {
+	m, err := NewMigrator(cfg)
+	if err != nil {
+		return NewError(err)
+	}
+	defer m.Close()
+	m.OnEvent = PrintEvent
+
+	return m.Repair(context.Background())
+}
+
+// Repair re-stamps the checksum and file name of every applied migration
+// whose file exists, accepting any changes made to them.
+func (m *Migrator) Repair(ctx context.Context) error {
+	return m.session(ctx, true, func(conn *sql.Conn) error {
+		exists, err := schemaMigrationsTableExists(ctx, conn, m.cfg)
+		if err != nil {
+			return NewError(err)
+		}
+		if !exists {
+			return schemaMigrationsTableMissingError(m.cfg)
+		}
+
+		err = ensureTableSchemaMigration(ctx, conn, m.cfg)
+		if err != nil {
+			return NewError(err)
+		}
+
+		mFiles, err := m.migrationFiles(Forward)
+		if err != nil {
+			return NewError(err)
+		}
+
+		applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+		if err != nil {
+			return NewError(err)
+		}
+
+		for i := range applied {
+			filePath, ok := mFiles[applied[i].Version]
+			if !ok {
+				m.notify(MigrationEvent{Kind: EventFileMissing, Version: applied[i].Version})
+				continue
+			}
+			if isGoMigrationFile(filePath) {
+				continue
+			}
+
+			current, err := fileChecksum(m.source(), filePath)
+			if err != nil {
+				return NewError(err)
+			}
+			if current == applied[i].Checksum && filepath.Base(filePath) == applied[i].FileName {
+				continue
+			}
+
+			_, err = conn.ExecContext(
+				ctx,
+				stmntUpdateSchemaMigrationChecksum,
+				m.cfg.Migration.Table.Schema,
+				m.cfg.Migration.Table.Name,
+				applied[i].Version,
+				current,
+				filepath.Base(filePath),
+			)
+			if err != nil {
+				return NewError(err)
+			}
+			m.notify(MigrationEvent{Kind: EventChecksumRepaired, Version: applied[i].Version, File: filePath})
+		}
+
+		return nil
+	})
+}
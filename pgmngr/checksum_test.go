@@ -0,0 +1,76 @@
+package pgmngr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksum(t *testing.T) {
+	require.Equal(
+		t,
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		checksum([]byte{}),
+	)
+	require.NotEqual(t, checksum([]byte("SELECT 1;")), checksum([]byte("SELECT 2;")))
+}
+
+func TestFindChecksumMismatches(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "migrations_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	unchanged := filepath.Join(tempDir, "1600000001_unchanged.up.sql")
+	err = ioutil.WriteFile(unchanged, []byte("SELECT 1;"), 0644)
+	require.NoError(t, err)
+
+	changed := filepath.Join(tempDir, "1600000002_changed.up.sql")
+	err = ioutil.WriteFile(changed, []byte("SELECT 2;"), 0644)
+	require.NoError(t, err)
+
+	mFiles := migrationFiles{
+		1600000001: unchanged,
+		1600000002: changed,
+	}
+	applied := []MigrationRecord{
+		{Version: 1600000001, Checksum: checksum([]byte("SELECT 1;"))},
+		{Version: 1600000002, Checksum: checksum([]byte("SELECT 3;"))},
+		// applied before checksums were recorded
+		{Version: 1600000003},
+		// file no longer exists
+		{Version: 1600000004, Checksum: checksum([]byte("SELECT 4;"))},
+	}
+
+	mismatches, err := findChecksumMismatches(NewDirectorySource(tempDir), mFiles, applied)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(mismatches))
+	require.Equal(t, int64(1600000002), mismatches[0].Version)
+	require.Equal(t, changed, mismatches[0].File)
+	require.Equal(t, checksum([]byte("SELECT 2;")), mismatches[0].Current)
+
+	err = checksumMismatchError(mismatches)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), changed)
+}
+
+func TestApplyMigrationChecksumMismatch(t *testing.T) {
+	m, tempDir := testMigrator(t, "checksum")
+	cfg := m.cfg
+
+	writeTestMigration(t, tempDir, 1600000001, "checksum", "SELECT 1;", "SELECT 1;")
+	err := ApplyMigration(Forward, cfg)
+	require.NoError(t, err)
+
+	writeTestMigration(t, tempDir, 1600000001, "checksum", "SELECT 2;", "SELECT 1;")
+	err = ApplyMigration(Forward, cfg)
+	require.Error(t, err)
+
+	err = RepairMigrationChecksums(cfg)
+	require.NoError(t, err)
+
+	err = ApplyMigration(Forward, cfg)
+	require.NoError(t, err)
+}
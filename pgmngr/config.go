@@ -2,30 +2,203 @@ package pgmngr
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 type cliContext interface {
 	String(s string) string
+	StringSlice(s string) []string
+	IsSet(s string) bool
 }
 
 // LoadConfig loads the configuration from a config file, ENV VARs or command
-// line arguments
+// line arguments. Flags take precedence over PGMNGR_* ENV VARs, which take
+// precedence over the config file, unset fields getting their defaults. The
+// config file is optional unless its path is given.
 func LoadConfig(ctx cliContext, cfg *Config) error {
 	configPath := ctx.String("config-file")
 
-	file, err := os.Open(configPath)
+	b, err := ioutil.ReadFile(configPath)
+	switch {
+	case os.IsNotExist(err) && !ctx.IsSet("config-file"):
+		b = []byte("{}")
+	case err != nil:
+		return NewError(err)
+	}
+
+	err = parseConfig(b, ctx.String("env"), cfg)
 	if err != nil {
 		return NewError(err)
 	}
-	defer file.Close()
 
-	b, err := ioutil.ReadAll(file)
+	for _, field := range configFieldPaths() {
+		value, ok := os.LookupEnv(ConfigEnvVar(field))
+		if !ok {
+			continue
+		}
+		err = setConfigField(cfg, field, value)
+		if err != nil {
+			return NewError(fmt.Errorf("%s: %v", ConfigEnvVar(field), err))
+		}
+	}
+
+	for _, flag := range ConfigFlags {
+		if !ctx.IsSet(flag.Name) {
+			continue
+		}
+		err = setConfigField(cfg, flag.Field, ctx.String(flag.Name))
+		if err != nil {
+			return NewError(fmt.Errorf("--%s: %v", flag.Name, err))
+		}
+	}
+
+	for _, set := range ctx.StringSlice("set") {
+		i := strings.Index(set, "=")
+		if i < 0 {
+			return NewError(fmt.Errorf("--set %s: expected field=value", set))
+		}
+		err = setConfigField(cfg, set[:i], set[i+1:])
+		if err != nil {
+			return NewError(fmt.Errorf("--set %s: %v", set, err))
+		}
+	}
+
+	cfg.setDefaults()
+
+	return nil
+}
+
+// ConfigFlag is a global flag overriding a config field, given as the path
+// of its JSON names.
+type ConfigFlag struct {
+	Name  string
+	Field string
+	Usage string
+}
+
+// ConfigFlags are the global flags overriding the most used config fields.
+// Every field can be overridden by `--set field=value` too.
+var ConfigFlags = []ConfigFlag{
+	{Name: "host", Field: "connection.migration.host", Usage: "The host of the database"},
+	{Name: "port", Field: "connection.migration.port", Usage: "The port of the database"},
+	{Name: "database", Field: "connection.migration.database", Usage: "The database to migrate"},
+	{Name: "username", Field: "connection.migration.username", Usage: "The user migrating the database"},
+	{Name: "password", Field: "connection.migration.password", Usage: "The password of the user migrating the database"},
+	{Name: "admin-username", Field: "connection.admin.username", Usage: "The user creating and dropping the database"},
+	{Name: "admin-password", Field: "connection.admin.password", Usage: "The password of the admin user"},
+	{Name: "migrations-dir", Field: "migration.directory", Usage: "The directory of the migration files"},
+}
+
+// ConfigEnvVar returns the ENV VAR overriding a config field, e.g.
+// PGMNGR_CONNECTION_MIGRATION_HOST for connection.migration.host.
+func ConfigEnvVar(field string) string {
+	return "PGMNGR_" + strings.ToUpper(strings.Replace(field, ".", "_", -1))
+}
+
+// configFieldPaths returns the paths of the config fields that can be
+// overridden. The environment is not one of them, it is selected before the
+// config file is read.
+func configFieldPaths() []string {
+	paths := make([]string, 0)
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			name := configFieldName(t.Field(i))
+			if name == "-" || prefix+name == "environment" {
+				continue
+			}
+			if t.Field(i).Type.Kind() == reflect.Struct {
+				walk(t.Field(i).Type, prefix+name+".")
+				continue
+			}
+			paths = append(paths, prefix+name)
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+	return paths
+}
+
+func configFieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// setConfigField sets the config field at the path of JSON names to the
+// value, maps being given as `key=value,key=value` which are merged into the
+// values already set.
+func setConfigField(cfg *Config, path, value string) error {
+	v := reflect.ValueOf(cfg).Elem()
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct || path == "environment" {
+			return NewError(fmt.Errorf("unknown config field: %s", path))
+		}
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if configFieldName(v.Type().Field(i)) == name {
+				v = v.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return NewError(fmt.Errorf("unknown config field: %s", path))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return NewError(fmt.Errorf("invalid integer: %s", value))
+		}
+		v.SetInt(int64(i))
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		iter := v.MapRange()
+		for iter.Next() {
+			m.SetMapIndex(iter.Key(), iter.Value())
+		}
+		for _, pair := range strings.Split(value, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			i := strings.Index(pair, "=")
+			if i < 0 {
+				return NewError(fmt.Errorf("expected key=value pairs: %s", value))
+			}
+			m.SetMapIndex(
+				reflect.ValueOf(strings.TrimSpace(pair[:i])).Convert(v.Type().Key()),
+				reflect.ValueOf(strings.TrimSpace(pair[i+1:])).Convert(v.Type().Elem()),
+			)
+		}
+		v.Set(m)
+	default:
+		return NewError(fmt.Errorf("unknown config field: %s", path))
+	}
+	return nil
+}
+
+// parseConfig reads the contents of a config file into cfg. The values of
+// the environment, or of the one set by the file when it is empty, override
+// the top-level values they inherit from.
+func parseConfig(b []byte, environment string, cfg *Config) error {
+	var file struct {
+		Environments map[string]json.RawMessage `json:"environments"`
+	}
+	err := json.Unmarshal(b, &file)
 	if err != nil {
 		return NewError(err)
 	}
@@ -35,6 +208,38 @@ func LoadConfig(ctx cliContext, cfg *Config) error {
 		return NewError(err)
 	}
 
+	if environment == "" {
+		environment = cfg.Environment
+	}
+	cfg.Environment = environment
+	if environment == "" || len(file.Environments) == 0 {
+		return nil
+	}
+
+	overrides, ok := file.Environments[environment]
+	if !ok {
+		names := make([]string, 0, len(file.Environments))
+		for name := range file.Environments {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return NewError(fmt.Errorf(
+			"unknown environment: %s, expected one of: %s", environment, strings.Join(names, ", "),
+		))
+	}
+
+	// only the fields set by the environment are replaced, and query params
+	// are merged
+	err = json.Unmarshal(overrides, cfg)
+	if err != nil {
+		return NewError(fmt.Errorf("environment %s: %v", environment, err))
+	}
+	cfg.Environment = environment
+	return nil
+}
+
+// setDefaults replaces unset fields with their default values.
+func (cfg *Config) setDefaults() {
 	// migration
 	if cfg.Connection.Migration.PingIntervals == 0 {
 		cfg.Connection.Migration.PingIntervals = 5
@@ -77,6 +282,22 @@ func LoadConfig(ctx cliContext, cfg *Config) error {
 		cfg.Migration.Table.Name = "schema_migrations"
 	}
 
+	if cfg.Migration.LockWaitTimeout == 0 {
+		cfg.Migration.LockWaitTimeout = 300
+	}
+
+	if cfg.Migration.OutOfOrder == "" {
+		cfg.Migration.OutOfOrder = OutOfOrderWarn
+	}
+
+	if cfg.Migration.Retry.Backoff == "" {
+		cfg.Migration.Retry.Backoff = "1s"
+	}
+
+	if cfg.Migration.Retry.MaxBackoff == "" {
+		cfg.Migration.Retry.MaxBackoff = "30s"
+	}
+
 	// admin defaults are lifted from the migration config
 	if cfg.Connection.Admin.PingIntervals == 0 {
 		cfg.Connection.Admin.PingIntervals = cfg.Connection.Migration.PingIntervals
@@ -114,13 +335,15 @@ func LoadConfig(ctx cliContext, cfg *Config) error {
 			cfg.Connection.Migration.QueryParams["sslmode"] = "disable"
 		}
 	}
-
-	return nil
 }
 
 // Config stores the options used by pgmngr.
 type Config struct {
-	Connection struct {
+	// Environment is the environment of the config file in use, selected
+	// with --env or PGMNGR_ENV. Migrations limited to other environments by
+	// their directives are left out.
+	Environment string `json:"environment,omitempty"`
+	Connection  struct {
 		Admin struct {
 			Username      string            `json:"username,omitempty"`
 			Password      string            `json:"password,omitempty"`
@@ -142,13 +365,47 @@ type Config struct {
 	} `json:"connection"`
 	Migration struct {
 		Directory string `json:"directory,omitempty"`
-		Table     struct {
+		// LockWaitTimeout is the number of seconds to wait for the migration
+		// lock held by another run.
+		LockWaitTimeout int    `json:"lock_wait_timeout,omitempty"`
+		OutOfOrder      string `json:"out_of_order,omitempty"`
+		Table           struct {
 			Schema string `json:"schema"`
 			Name   string `json:"name"`
 		} `json:"table,omitempty"`
+		// SchemaFile, when set, is written with the schema of the database
+		// after migrating forward.
+		SchemaFile string `json:"schema_file,omitempty"`
+		// LockTimeout and StatementTimeout are set, as Go durations, for
+		// every migration unless overridden by the directives of the
+		// migration file.
+		LockTimeout      string `json:"lock_timeout,omitempty"`
+		StatementTimeout string `json:"statement_timeout,omitempty"`
+		// Retry re-attempts migrations that failed because a lock was not
+		// available within the lock timeout.
+		Retry struct {
+			Attempts   int    `json:"attempts,omitempty"`
+			Backoff    string `json:"backoff,omitempty"`
+			MaxBackoff string `json:"max_backoff,omitempty"`
+		} `json:"retry,omitempty"`
+		// Lint sets the severity of the lint rules by rule: error, warning or
+		// off.
+		Lint struct {
+			Rules map[string]LintSeverity `json:"rules,omitempty"`
+		} `json:"lint,omitempty"`
 	} `json:"migration"`
 }
 
+// Policies for pending migrations older than the newest applied migration.
+const (
+	// OutOfOrderAllow applies them silently
+	OutOfOrderAllow = "allow"
+	// OutOfOrderWarn applies them and reports them
+	OutOfOrderWarn = "warn"
+	// OutOfOrderError refuses to apply any migration
+	OutOfOrderError = "error"
+)
+
 const postgresScheme = "postgres"
 
 func (c *Config) dbURL() (string, error) {
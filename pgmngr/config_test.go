@@ -1,6 +1,9 @@
 package pgmngr
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -62,3 +65,225 @@ func TestConfig_dbURL(t *testing.T) {
 		)
 	})
 }
+
+func TestConfig_setDefaults(t *testing.T) {
+	t.Run("unset fields", func(t *testing.T) {
+		config := Config{}
+		config.setDefaults()
+		require.Equal(t, "localhost", config.Connection.Migration.Host)
+		require.Equal(t, 5432, config.Connection.Migration.Port)
+		require.Equal(t, "public", config.Migration.Table.Schema)
+		require.Equal(t, "schema_migrations", config.Migration.Table.Name)
+		require.Equal(t, 300, config.Migration.LockWaitTimeout)
+		require.Equal(t, OutOfOrderWarn, config.Migration.OutOfOrder)
+	})
+
+	t.Run("set fields", func(t *testing.T) {
+		config := Config{}
+		config.Migration.LockWaitTimeout = 10
+		config.Migration.OutOfOrder = OutOfOrderError
+		config.setDefaults()
+		require.Equal(t, 10, config.Migration.LockWaitTimeout)
+		require.Equal(t, OutOfOrderError, config.Migration.OutOfOrder)
+	})
+}
+
+func TestParseConfig(t *testing.T) {
+	b := []byte(`{
+  "connection": {
+    "migration": {
+      "host": "localhost",
+      "username": "app",
+      "database": "app_dev",
+      "query_params": {"sslmode": "disable"}
+    }
+  },
+  "migration": {"directory": "migrations"},
+  "environments": {
+    "test": {"connection": {"migration": {"database": "app_test"}}},
+    "prod": {
+      "connection": {
+        "migration": {
+          "host": "db.internal",
+          "database": "app",
+          "query_params": {"sslmode": "require", "connect_timeout": "5"}
+        }
+      },
+      "migration": {"out_of_order": "error"}
+    }
+  }
+}`)
+
+	t.Run("top-level values", func(t *testing.T) {
+		config := Config{}
+		err := parseConfig(b, "", &config)
+		require.NoError(t, err)
+		require.Equal(t, "", config.Environment)
+		require.Equal(t, "app_dev", config.Connection.Migration.Database)
+	})
+
+	t.Run("inherited values", func(t *testing.T) {
+		config := Config{}
+		err := parseConfig(b, "test", &config)
+		require.NoError(t, err)
+		require.Equal(t, "test", config.Environment)
+		require.Equal(t, "app_test", config.Connection.Migration.Database)
+		require.Equal(t, "localhost", config.Connection.Migration.Host)
+		require.Equal(t, "app", config.Connection.Migration.Username)
+		require.Equal(t, "migrations", config.Migration.Directory)
+	})
+
+	t.Run("overridden values", func(t *testing.T) {
+		config := Config{}
+		err := parseConfig(b, "prod", &config)
+		require.NoError(t, err)
+		require.Equal(t, "db.internal", config.Connection.Migration.Host)
+		require.Equal(t, "app", config.Connection.Migration.Database)
+		require.Equal(
+			t,
+			map[string]string{"sslmode": "require", "connect_timeout": "5"},
+			config.Connection.Migration.QueryParams,
+		)
+		require.Equal(t, OutOfOrderError, config.Migration.OutOfOrder)
+	})
+
+	t.Run("environment set by the file", func(t *testing.T) {
+		config := Config{}
+		err := parseConfig([]byte(`{"environment": "test", "environments": {"test": {"migration": {"directory": "t"}}}}`), "", &config)
+		require.NoError(t, err)
+		require.Equal(t, "test", config.Environment)
+		require.Equal(t, "t", config.Migration.Directory)
+	})
+
+	t.Run("unknown environment", func(t *testing.T) {
+		config := Config{}
+		err := parseConfig(b, "staging", &config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown environment: staging, expected one of: prod, test")
+	})
+
+	t.Run("no environments", func(t *testing.T) {
+		config := Config{}
+		err := parseConfig([]byte(`{"migration": {"directory": "migrations"}}`), "ci", &config)
+		require.NoError(t, err)
+		require.Equal(t, "ci", config.Environment)
+
+		m := NewMigratorWithDB(nil, &config)
+		require.Equal(t, "ci", m.Environment)
+	})
+}
+
+type mapCliContext struct {
+	strings map[string]string
+	slices  map[string][]string
+}
+
+func (c *mapCliContext) String(s string) string {
+	return c.strings[s]
+}
+
+func (c *mapCliContext) StringSlice(s string) []string {
+	return c.slices[s]
+}
+
+func (c *mapCliContext) IsSet(s string) bool {
+	_, ok := c.strings[s]
+	return ok
+}
+
+func TestLoadConfig(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "config_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	configPath := filepath.Join(tempDir, "pgmngr.json")
+	err = ioutil.WriteFile(configPath, []byte(`{
+  "connection": {"migration": {"host": "file-host", "port": 5433, "database": "file_db", "username": "file_user", "query_params": {"sslmode": "require"}}},
+  "migration": {"directory": "migrations"}
+}`), 0644)
+	require.NoError(t, err)
+
+	setEnv := func(key, value string) {
+		err := os.Setenv(key, value)
+		require.NoError(t, err)
+	}
+	defer os.Unsetenv("PGMNGR_CONNECTION_MIGRATION_HOST")
+	defer os.Unsetenv("PGMNGR_CONNECTION_MIGRATION_DATABASE")
+	defer os.Unsetenv("PGMNGR_CONNECTION_MIGRATION_QUERY_PARAMS")
+	defer os.Unsetenv("PGMNGR_MIGRATION_RETRY_ATTEMPTS")
+	setEnv("PGMNGR_CONNECTION_MIGRATION_HOST", "env-host")
+	setEnv("PGMNGR_CONNECTION_MIGRATION_DATABASE", "env_db")
+	setEnv("PGMNGR_CONNECTION_MIGRATION_QUERY_PARAMS", "connect_timeout=5")
+	setEnv("PGMNGR_MIGRATION_RETRY_ATTEMPTS", "3")
+
+	t.Run("precedence", func(t *testing.T) {
+		config := Config{}
+		err := LoadConfig(&mapCliContext{
+			strings: map[string]string{"config-file": configPath, "database": "flag_db"},
+			slices:  map[string][]string{"set": {"migration.table.schema=app"}},
+		}, &config)
+		require.NoError(t, err)
+		// flag over env over file over defaults, maps being merged
+		require.Equal(t, "flag_db", config.Connection.Migration.Database)
+		require.Equal(t, "env-host", config.Connection.Migration.Host)
+		require.Equal(t, 5433, config.Connection.Migration.Port)
+		require.Equal(t, "file_user", config.Connection.Migration.Username)
+		require.Equal(t, "schema_migrations", config.Migration.Table.Name)
+		require.Equal(t, "app", config.Migration.Table.Schema)
+		require.Equal(t, 3, config.Migration.Retry.Attempts)
+		require.Equal(
+			t,
+			map[string]string{"sslmode": "require", "connect_timeout": "5"},
+			config.Connection.Migration.QueryParams,
+		)
+	})
+
+	t.Run("no config file", func(t *testing.T) {
+		config := Config{}
+		err := LoadConfig(&mapCliContext{
+			strings: map[string]string{"port": "6432"},
+		}, &config)
+		require.NoError(t, err)
+		require.Equal(t, "env-host", config.Connection.Migration.Host)
+		require.Equal(t, 6432, config.Connection.Migration.Port)
+		require.Equal(t, "public", config.Migration.Table.Schema)
+	})
+
+	t.Run("missing config file given", func(t *testing.T) {
+		config := Config{}
+		err := LoadConfig(&mapCliContext{
+			strings: map[string]string{"config-file": filepath.Join(tempDir, "missing.json")},
+		}, &config)
+		require.Error(t, err)
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		config := Config{}
+		err := LoadConfig(&mapCliContext{
+			strings: map[string]string{"config-file": configPath, "port": "abc"},
+		}, &config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "--port: invalid integer: abc")
+
+		err = LoadConfig(&mapCliContext{
+			strings: map[string]string{"config-file": configPath},
+			slices:  map[string][]string{"set": {"migration.unknown=1"}},
+		}, &config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown config field: migration.unknown")
+	})
+}
+
+func TestConfigFieldPaths(t *testing.T) {
+	paths := configFieldPaths()
+	require.Contains(t, paths, "connection.migration.host")
+	require.Contains(t, paths, "connection.admin.template_database")
+	require.Contains(t, paths, "migration.lock_timeout")
+	require.Contains(t, paths, "migration.lock_wait_timeout")
+	require.Contains(t, paths, "migration.lint.rules")
+	require.NotContains(t, paths, "environment")
+	for _, flag := range ConfigFlags {
+		require.Contains(t, paths, flag.Field)
+	}
+	require.Equal(t, "PGMNGR_CONNECTION_MIGRATION_HOST", ConfigEnvVar("connection.migration.host"))
+}
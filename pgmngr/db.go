@@ -1,6 +1,7 @@
 package pgmngr
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 )
@@ -174,6 +175,7 @@ func DropDatabase(cfg Config) error {
 	return nil
 }
 
+// ResetDatabase drops and creates the database, then applies every migration.
 func ResetDatabase(cfg Config) error {
 	err := DropDatabase(cfg)
 	if err != nil {
@@ -189,3 +191,35 @@ func ResetDatabase(cfg Config) error {
 	}
 	return nil
 }
+
+// ResetDatabaseFromSchema drops and creates the database like ResetDatabase,
+// but loads the schema file written by DumpSchema instead of replaying the
+// migrations it includes. Migrations newer than the schema are applied after.
+func ResetDatabaseFromSchema(cfg Config, filePath string) error {
+	err := DropDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	err = CreateDatabase(cfg)
+	if err != nil {
+		return err
+	}
+
+	m, err := NewMigrator(&cfg)
+	if err != nil {
+		return NewError(err)
+	}
+	defer m.Close()
+	m.OnEvent = PrintEvent
+
+	_, err = m.LoadSchema(context.Background(), filePath)
+	if err != nil {
+		return NewError(err)
+	}
+
+	_, err = m.Up(context.Background())
+	if err != nil {
+		return NewError(err)
+	}
+	return nil
+}
@@ -0,0 +1,463 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gookit/color"
+)
+
+// diffHeader starts the migration files written from a schema diff.
+const diffHeader = "-- Generated by pgmngr db diff, review before applying.\n\n"
+
+// SchemaDiff holds the statements changing the schema of a database into the
+// one of another, and back.
+type SchemaDiff struct {
+	Up   []string `json:"up"`
+	Down []string `json:"down"`
+}
+
+// Empty returns true when the schemas are the same.
+func (d SchemaDiff) Empty() bool {
+	return len(d.Up) == 0 && len(d.Down) == 0
+}
+
+// DiffDatabases compares the schemas of the source and target databases,
+// given as URLs, leaving out the excluded `schema.table` tables. Up changes
+// the source into the target, and Down the target back into the source.
+func DiffDatabases(ctx context.Context, sourceURL, targetURL string, exclude []string) (SchemaDiff, error) {
+	source, err := readDatabaseSchema(ctx, sourceURL, exclude)
+	if err != nil {
+		return SchemaDiff{}, NewError(err)
+	}
+	target, err := readDatabaseSchema(ctx, targetURL, exclude)
+	if err != nil {
+		return SchemaDiff{}, NewError(err)
+	}
+
+	up, err := schemaChanges(source, target)
+	if err != nil {
+		return SchemaDiff{}, NewError(err)
+	}
+	down, err := schemaChanges(target, source)
+	if err != nil {
+		return SchemaDiff{}, NewError(err)
+	}
+	return SchemaDiff{Up: up, Down: down}, nil
+}
+
+func readDatabaseSchema(ctx context.Context, dbURL string, exclude []string) (*schemaDump, error) {
+	db, err := sql.Open(pgDriver, dbURL)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	defer conn.Close()
+
+	d, err := readSchema(ctx, conn, exclude)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	return d, nil
+}
+
+// CreateDiffMigration writes a migration, named like the ones of
+// CreateMigration, changing the schema of the source database into the one of
+// the target database. The configured database is the source when sourceURL
+// is empty. Nothing is written when the schemas are the same.
+func CreateDiffMigration(ctx context.Context, c *Config, name, sourceURL, targetURL string) error {
+	if sourceURL == "" {
+		var err error
+		sourceURL, err = c.dbURL()
+		if err != nil {
+			return NewError(err)
+		}
+	}
+
+	diff, err := DiffDatabases(ctx, sourceURL, targetURL, trackingTables(c))
+	if err != nil {
+		return NewError(err)
+	}
+	if diff.Empty() {
+		color.Info.Tips("The schemas of the databases are the same, no migration created")
+		return nil
+	}
+
+	up := diffHeader + strings.Join(diff.Up, "\n") + "\n"
+	down := diffHeader + strings.Join(diff.Down, "\n") + "\n"
+	return writeMigrationFiles(c, name, false, []byte(up), []byte(down))
+}
+
+// schemaChanges returns the statements changing the schema read as from into
+// the one read as to. Objects that are gone, or that cannot be altered, are
+// dropped first along with the objects depending on them, such as the
+// indexes of a table or the views using a function, in the reverse order of
+// the dump. Then objects are created, created again or altered in the order of
+// the dump, so dependencies come first.
+func schemaChanges(from, to *schemaDump) ([]string, error) {
+	fromDefs, fromObjects, err := diffObjects(from)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	toDefs, toObjects, err := diffObjects(to)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	dependents := make(map[string][]string)
+	for object, dependencies := range objectDependencies(from, fromDefs) {
+		for _, dependency := range dependencies {
+			dependents[dependency] = append(dependents[dependency], object)
+		}
+	}
+	dropped := make(map[string]bool)
+	var drop func(object string)
+	drop = func(object string) {
+		if dropped[object] {
+			return
+		}
+		dropped[object] = true
+		for _, dependent := range dependents[object] {
+			drop(dependent)
+		}
+	}
+	for _, object := range fromObjects {
+		toDef, ok := toDefs[object]
+		if !ok || (toDef != fromDefs[object] && !alterable(from, to, fromDefs[object], toDef)) {
+			drop(object)
+		}
+	}
+
+	stmnts := make([]string, 0)
+	for i := len(fromObjects) - 1; i >= 0; i-- {
+		if dropped[fromObjects[i]] {
+			stmnts = append(stmnts, dropStatement(fromObjects[i], fromDefs[fromObjects[i]]))
+		}
+	}
+
+	for _, object := range toObjects {
+		fromDef, ok := fromDefs[object]
+		switch {
+		case !ok || dropped[object]:
+			stmnts = append(stmnts, toDefs[object])
+		case fromDef == toDefs[object]:
+		default:
+			kind, names := schemaObjectNames(toDefs[object])
+			if kind == "table" {
+				alter, ok := alterTable(from.tablesByName[names[0]], to.tablesByName[names[0]])
+				if ok {
+					stmnts = append(stmnts, alter...)
+					continue
+				}
+			}
+			// replaced by the definition
+			stmnts = append(stmnts, toDefs[object])
+		}
+	}
+
+	return stmnts, nil
+}
+
+// diffObjects returns the objects of a schema dump like schemaObjects, the
+// functions being identified by the types of their arguments, as they are
+// when dropped, rather than by the arguments they are created with, which
+// include their names and defaults.
+func diffObjects(d *schemaDump) (map[string]string, []string, error) {
+	defs, objects, err := schemaObjects([]byte(d.String()))
+	if err != nil {
+		return nil, nil, NewError(err)
+	}
+
+	for i, object := range objects {
+		def := defs[object]
+		kind, names := schemaObjectNames(def)
+		if kind != "function" {
+			continue
+		}
+		identity, ok := d.functionIdentities[names[0]]
+		if !ok {
+			continue
+		}
+		delete(defs, object)
+		objects[i] = "function " + identity
+		defs[objects[i]] = def
+	}
+	return defs, objects, nil
+}
+
+var (
+	// sequenceOwnerRegex matches the column owning a sequence.
+	sequenceOwnerRegex = regexp.MustCompile(` OWNED BY ` + schemaName)
+	// referencesRegex matches the table referenced by a foreign key.
+	referencesRegex = regexp.MustCompile(`\bREFERENCES ` + schemaName)
+	// triggerFunctionRegex matches the function executed by a trigger.
+	triggerFunctionRegex = regexp.MustCompile(`\bEXECUTE (?:FUNCTION|PROCEDURE) ` + schemaName + `\(`)
+)
+
+// objectDependencies returns the objects of a schema dump that every object
+// depends on, such as the table of an index, the function of a trigger or the
+// tables of a view. Objects that are not in the dump may be returned too.
+func objectDependencies(d *schemaDump, defs map[string]string) map[string][]string {
+	// the functions by name, and the constraints and indexes by table
+	functions := make(map[string][]string)
+	keys := make(map[string][]string)
+	for object, def := range defs {
+		kind, names := schemaObjectNames(def)
+		switch kind {
+		case "function":
+			name := strings.TrimPrefix(object, "function ")
+			if i := strings.Index(name, "("); i >= 0 {
+				name = name[:i]
+			}
+			functions[name] = append(functions[name], object)
+		case "constraint":
+			keys[names[0]] = append(keys[names[0]], object)
+		case "index":
+			keys[names[1]] = append(keys[names[1]], object)
+		}
+	}
+
+	dependencies := make(map[string][]string)
+	for object, def := range defs {
+		kind, names := schemaObjectNames(def)
+		switch kind {
+		case "sequence owner":
+			dependencies[object] = []string{"sequence " + names[0]}
+			if match := sequenceOwnerRegex.FindStringSubmatch(def); match != nil {
+				column := strings.TrimSuffix(match[1], ";")
+				dependencies[object] = append(dependencies[object], "table "+qualifierName(column))
+			}
+		case "table":
+			if t, ok := d.tablesByName[names[0]]; ok && t.parent != "" {
+				dependencies[object] = []string{"table " + t.parent}
+			}
+		case "constraint":
+			dependencies[object] = []string{"table " + names[0]}
+			if match := referencesRegex.FindStringSubmatch(def); match != nil {
+				// the foreign key uses a key of the referenced table
+				dependencies[object] = append(dependencies[object], "table "+match[1])
+				dependencies[object] = append(dependencies[object], keys[match[1]]...)
+			}
+		case "index":
+			dependencies[object] = []string{"table " + names[1], "view " + names[1]}
+		case "view":
+			dependencies[object] = d.viewDependencies[object]
+		case "trigger":
+			dependencies[object] = []string{"table " + names[1], "view " + names[1]}
+			if match := triggerFunctionRegex.FindStringSubmatch(def); match != nil {
+				dependencies[object] = append(dependencies[object], functions[match[1]]...)
+			}
+		case "comment on":
+			dependencies[object] = []string{commentedObject(names[0])}
+		}
+	}
+	return dependencies
+}
+
+// commentedObject returns the object of a COMMENT ON statement given the kind
+// and name following COMMENT ON, e.g. `table public.people` for `COLUMN
+// public.people.name`.
+func commentedObject(target string) string {
+	if strings.HasPrefix(target, "MATERIALIZED VIEW ") {
+		return "view " + strings.TrimPrefix(target, "MATERIALIZED VIEW ")
+	}
+	i := strings.Index(target, " ")
+	if i < 0 {
+		return target
+	}
+	kind, name := target[:i], target[i+1:]
+	switch kind {
+	case "COLUMN":
+		return "table " + qualifierName(name)
+	case "CONSTRAINT", "TRIGGER":
+		// e.g. CONSTRAINT pets_pk ON public.pets
+		return strings.ToLower(kind) + " " + strings.Replace(name, " ON ", " on ", 1)
+	case "PROCEDURE":
+		return "function " + name
+	}
+	return strings.ToLower(kind) + " " + name
+}
+
+// alterable returns true when the object defined by fromDef can be changed
+// in place into the one defined by toDef, rather than being dropped and
+// created again.
+func alterable(from, to *schemaDump, fromDef, toDef string) bool {
+	kind, names := schemaObjectNames(toDef)
+	switch kind {
+	case "function":
+		// CREATE OR REPLACE cannot change the return type, nor the names
+		// and defaults of the arguments
+		_, fromNames := schemaObjectNames(fromDef)
+		return fromNames[0] == names[0] && functionReturns(fromDef) == functionReturns(toDef)
+	case "sequence owner", "comment on":
+		return true
+	case "table":
+		_, ok := alterTable(from.tablesByName[names[0]], to.tablesByName[names[0]])
+		return ok
+	}
+	return false
+}
+
+// functionReturnsRegex matches the RETURNS line of pg_get_functiondef.
+var functionReturnsRegex = regexp.MustCompile(`(?m)^ RETURNS ([^\n]+)$`)
+
+// functionReturns returns the return type of the function defined by def, an
+// empty string for procedures.
+func functionReturns(def string) string {
+	match := functionReturnsRegex.FindStringSubmatch(def)
+	if match == nil {
+		return ""
+	}
+	return strings.TrimSpace(match[1])
+}
+
+// dropStatement returns the statement dropping the object defined by def,
+// functions being dropped by their object, which holds the types of their
+// arguments.
+func dropStatement(object, def string) string {
+	kind, names := schemaObjectNames(def)
+	switch kind {
+	case "schema", "extension", "type", "domain", "sequence", "table":
+		return fmt.Sprintf("DROP %s %s;", strings.ToUpper(kind), names[0])
+	case "sequence owner":
+		return fmt.Sprintf("ALTER SEQUENCE %s OWNED BY NONE;", names[0])
+	case "function":
+		// covers procedures too
+		return fmt.Sprintf("DROP ROUTINE %s;", strings.TrimPrefix(object, "function "))
+	case "constraint":
+		return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", names[0], names[1])
+	case "index":
+		// the index is in the schema of its table
+		return fmt.Sprintf("DROP INDEX %s%s;", schemaPrefix(names[1]), names[0])
+	case "view":
+		if strings.HasPrefix(def, "CREATE MATERIALIZED VIEW") {
+			return fmt.Sprintf("DROP MATERIALIZED VIEW %s;", names[0])
+		}
+		return fmt.Sprintf("DROP VIEW %s;", names[0])
+	case "trigger":
+		return fmt.Sprintf("DROP TRIGGER %s ON %s;", names[0], names[1])
+	case "comment on":
+		return fmt.Sprintf("COMMENT ON %s IS NULL;", names[0])
+	}
+	return "-- not reverted, revert by hand:\n-- " + strings.Replace(def, "\n", "\n-- ", -1)
+}
+
+// qualifierName returns the name a name is qualified with, e.g. the table
+// `public.people` of the column `public.people.id`.
+func qualifierName(name string) string {
+	quoted := false
+	last := 0
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '"':
+			quoted = !quoted
+		case '.':
+			if !quoted {
+				last = i
+			}
+		}
+	}
+	return name[:last]
+}
+
+// schemaPrefix returns the `schema.` prefix of a schema qualified name.
+func schemaPrefix(name string) string {
+	quoted := false
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '"':
+			quoted = !quoted
+		case '.':
+			if !quoted {
+				return name[:i+1]
+			}
+		}
+	}
+	return ""
+}
+
+// alterTable returns the statements changing the columns of a table, false
+// being returned when the table has to be created again, e.g. when it is
+// partitioned differently.
+func alterTable(from, to *dumpedTable) ([]string, bool) {
+	if from == nil || to == nil ||
+		from.partKey != to.partKey || from.parent != to.parent || from.partBound != to.partBound {
+		return nil, false
+	}
+
+	stmnts := make([]string, 0)
+	for _, c := range from.columns {
+		if to.column(c.name) == nil {
+			stmnts = append(stmnts, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", to.name, c.name))
+		}
+	}
+	for _, c := range to.columns {
+		fromColumn := from.column(c.name)
+		if fromColumn == nil {
+			stmnts = append(stmnts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", to.name, c.definition()))
+			continue
+		}
+		stmnts = append(stmnts, alterColumn(to.name, fromColumn, c)...)
+	}
+	return stmnts, true
+}
+
+// alterColumn returns the statements changing a column of the table. The
+// identity is dropped before, and added after, the other changes since
+// identity columns have no default and are NOT NULL.
+func alterColumn(table string, from, to *dumpedColumn) []string {
+	if from.generated != to.generated || (to.generated != "" && from.defaultValue != to.defaultValue) {
+		// generated columns cannot be altered
+		return []string{
+			fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", table, to.name),
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", table, to.definition()),
+		}
+	}
+
+	alter := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s ", table, to.name)
+	stmnts := make([]string, 0)
+	if from.identity != "" && to.identity == "" {
+		stmnts = append(stmnts, alter+"DROP IDENTITY;")
+	}
+	if from.dataType != to.dataType || from.collation != to.collation {
+		stmnt := alter + "TYPE " + to.dataType
+		if to.collation != "" {
+			stmnt += " COLLATE " + to.collation
+		}
+		stmnts = append(stmnts, stmnt+";")
+	}
+	if from.defaultValue != to.defaultValue {
+		if to.defaultValue == "" {
+			stmnts = append(stmnts, alter+"DROP DEFAULT;")
+		} else {
+			stmnts = append(stmnts, alter+"SET DEFAULT "+to.defaultValue+";")
+		}
+	}
+	if from.notNull != to.notNull {
+		if to.notNull {
+			stmnts = append(stmnts, alter+"SET NOT NULL;")
+		} else {
+			stmnts = append(stmnts, alter+"DROP NOT NULL;")
+		}
+	}
+	if to.identity != "" && from.identity != to.identity {
+		generated := "ALWAYS"
+		if to.identity == "d" {
+			generated = "BY DEFAULT"
+		}
+		if from.identity == "" {
+			stmnts = append(stmnts, alter+"ADD GENERATED "+generated+" AS IDENTITY;")
+		} else {
+			stmnts = append(stmnts, alter+"SET GENERATED "+generated+";")
+		}
+	}
+	return stmnts
+}
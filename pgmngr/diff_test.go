@@ -0,0 +1,349 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testSchemaDump returns a schemaDump as read by readSchema.
+func testSchemaDump(dump string, tables ...*dumpedTable) *schemaDump {
+	d := &schemaDump{tablesByName: make(map[string]*dumpedTable)}
+	d.WriteString(dump)
+	for _, t := range tables {
+		d.tablesByName[t.name] = t
+	}
+	return d
+}
+
+func TestSchemaChanges(t *testing.T) {
+	from := testSchemaDump(`
+CREATE TABLE public.people (
+    id integer NOT NULL,
+    name text
+);
+CREATE INDEX people_id_idx ON public.people USING btree (id);
+CREATE VIEW public.named AS
+ SELECT people.name FROM public.people;
+`, &dumpedTable{
+		name: "public.people",
+		columns: []*dumpedColumn{
+			{name: "id", dataType: "integer", notNull: true},
+			{name: "name", dataType: "text"},
+		},
+	})
+	to := testSchemaDump(`
+CREATE TABLE public.people (
+    id integer NOT NULL,
+    name text DEFAULT 'x'::text NOT NULL,
+    age integer
+);
+CREATE TABLE public.pets (
+    id integer NOT NULL
+);
+CREATE VIEW public.named AS
+ SELECT people.name, people.age FROM public.people;
+`, &dumpedTable{
+		name: "public.people",
+		columns: []*dumpedColumn{
+			{name: "id", dataType: "integer", notNull: true},
+			{name: "name", dataType: "text", defaultValue: "'x'::text", notNull: true},
+			{name: "age", dataType: "integer"},
+		},
+	}, &dumpedTable{
+		name: "public.pets",
+		columns: []*dumpedColumn{
+			{name: "id", dataType: "integer", notNull: true},
+		},
+	})
+
+	up, err := schemaChanges(from, to)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"DROP VIEW public.named;",
+		"DROP INDEX public.people_id_idx;",
+		"ALTER TABLE public.people ALTER COLUMN name SET DEFAULT 'x'::text;",
+		"ALTER TABLE public.people ALTER COLUMN name SET NOT NULL;",
+		"ALTER TABLE public.people ADD COLUMN age integer;",
+		"CREATE TABLE public.pets (\n    id integer NOT NULL\n);",
+		"CREATE VIEW public.named AS\n SELECT people.name, people.age FROM public.people;",
+	}, up)
+
+	down, err := schemaChanges(to, from)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"DROP VIEW public.named;",
+		"DROP TABLE public.pets;",
+		"ALTER TABLE public.people DROP COLUMN age;",
+		"ALTER TABLE public.people ALTER COLUMN name DROP DEFAULT;",
+		"ALTER TABLE public.people ALTER COLUMN name DROP NOT NULL;",
+		"CREATE INDEX people_id_idx ON public.people USING btree (id);",
+		"CREATE VIEW public.named AS\n SELECT people.name FROM public.people;",
+	}, down)
+
+	none, err := schemaChanges(from, from)
+	require.NoError(t, err)
+	require.Empty(t, none)
+
+	t.Run("function return type", func(t *testing.T) {
+		from := testSchemaDump(`
+CREATE OR REPLACE FUNCTION public.answer()
+ RETURNS integer
+ LANGUAGE sql
+AS $function$SELECT 42$function$;
+CREATE OR REPLACE FUNCTION public.greet(name text)
+ RETURNS text
+ LANGUAGE sql
+AS $function$SELECT 'hi ' || name$function$;
+`)
+		to := testSchemaDump(`
+CREATE OR REPLACE FUNCTION public.answer()
+ RETURNS bigint
+ LANGUAGE sql
+AS $function$SELECT 42$function$;
+CREATE OR REPLACE FUNCTION public.greet(name text)
+ RETURNS text
+ LANGUAGE sql
+AS $function$SELECT 'hello ' || name$function$;
+`)
+
+		up, err := schemaChanges(from, to)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"DROP ROUTINE public.answer();",
+			"CREATE OR REPLACE FUNCTION public.answer()\n RETURNS bigint\n LANGUAGE sql\nAS $function$SELECT 42$function$;",
+			"CREATE OR REPLACE FUNCTION public.greet(name text)\n RETURNS text\n LANGUAGE sql\nAS $function$SELECT 'hello ' || name$function$;",
+		}, up)
+	})
+
+	t.Run("recreated table dependents", func(t *testing.T) {
+		columns := []*dumpedColumn{
+			{name: "id", dataType: "integer", notNull: true},
+			{name: "at", dataType: "date", notNull: true},
+		}
+		from := testSchemaDump(`
+CREATE OR REPLACE FUNCTION public.touch()
+ RETURNS trigger
+ LANGUAGE plpgsql
+AS $function$BEGIN RETURN NEW; END$function$;
+CREATE TABLE public.events (
+    id integer NOT NULL,
+    at date NOT NULL
+);
+CREATE INDEX events_at_idx ON public.events USING btree (at);
+CREATE VIEW public.recent AS
+ SELECT events.id FROM public.events;
+CREATE TRIGGER events_touch BEFORE UPDATE ON public.events FOR EACH ROW EXECUTE FUNCTION public.touch();
+COMMENT ON TABLE public.events IS 'events';
+`, &dumpedTable{name: "public.events", columns: columns})
+		from.viewDependencies = map[string][]string{"view public.recent": {"table public.events"}}
+		to := testSchemaDump(`
+CREATE OR REPLACE FUNCTION public.touch()
+ RETURNS trigger
+ LANGUAGE plpgsql
+AS $function$BEGIN RETURN NEW; END$function$;
+CREATE TABLE public.events (
+    id integer NOT NULL,
+    at date NOT NULL
+) PARTITION BY RANGE (at);
+CREATE INDEX events_at_idx ON ONLY public.events USING btree (at);
+CREATE VIEW public.recent AS
+ SELECT events.id FROM public.events;
+CREATE TRIGGER events_touch BEFORE UPDATE ON public.events FOR EACH ROW EXECUTE FUNCTION public.touch();
+COMMENT ON TABLE public.events IS 'events';
+`, &dumpedTable{name: "public.events", columns: columns, partKey: "RANGE (at)"})
+		to.viewDependencies = from.viewDependencies
+
+		up, err := schemaChanges(from, to)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"COMMENT ON TABLE public.events IS NULL;",
+			"DROP TRIGGER events_touch ON public.events;",
+			"DROP VIEW public.recent;",
+			"DROP INDEX public.events_at_idx;",
+			"DROP TABLE public.events;",
+			"CREATE TABLE public.events (\n    id integer NOT NULL,\n    at date NOT NULL\n) PARTITION BY RANGE (at);",
+			"CREATE INDEX events_at_idx ON ONLY public.events USING btree (at);",
+			"CREATE VIEW public.recent AS\n SELECT events.id FROM public.events;",
+			"CREATE TRIGGER events_touch BEFORE UPDATE ON public.events FOR EACH ROW EXECUTE FUNCTION public.touch();",
+			"COMMENT ON TABLE public.events IS 'events';",
+		}, up)
+	})
+
+	t.Run("recreated function dependents", func(t *testing.T) {
+		from := testSchemaDump(`
+CREATE OR REPLACE FUNCTION public.answer()
+ RETURNS integer
+ LANGUAGE sql
+AS $function$SELECT 42$function$;
+CREATE VIEW public.answers AS
+ SELECT public.answer() AS answer;
+CREATE VIEW public.all_answers AS
+ SELECT answers.answer FROM public.answers;
+`)
+		from.viewDependencies = map[string][]string{
+			"view public.answers":     {"function public.answer()"},
+			"view public.all_answers": {"view public.answers"},
+		}
+		to := testSchemaDump(`
+CREATE OR REPLACE FUNCTION public.answer()
+ RETURNS bigint
+ LANGUAGE sql
+AS $function$SELECT 42$function$;
+CREATE VIEW public.answers AS
+ SELECT public.answer() AS answer;
+CREATE VIEW public.all_answers AS
+ SELECT answers.answer FROM public.answers;
+`)
+
+		up, err := schemaChanges(from, to)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"DROP VIEW public.all_answers;",
+			"DROP VIEW public.answers;",
+			"DROP ROUTINE public.answer();",
+			"CREATE OR REPLACE FUNCTION public.answer()\n RETURNS bigint\n LANGUAGE sql\nAS $function$SELECT 42$function$;",
+			"CREATE VIEW public.answers AS\n SELECT public.answer() AS answer;",
+			"CREATE VIEW public.all_answers AS\n SELECT answers.answer FROM public.answers;",
+		}, up)
+	})
+
+	t.Run("function argument defaults", func(t *testing.T) {
+		from := testSchemaDump(`
+CREATE OR REPLACE FUNCTION public.greet(name text DEFAULT 'you'::text)
+ RETURNS text
+ LANGUAGE sql
+AS $function$SELECT 'hi ' || name$function$;
+`)
+		from.functionIdentities = map[string]string{"public.greet(name text DEFAULT 'you'::text)": "public.greet(text)"}
+		to := testSchemaDump(`
+CREATE OR REPLACE FUNCTION public.greet(name text)
+ RETURNS text
+ LANGUAGE sql
+AS $function$SELECT 'hi ' || name$function$;
+`)
+		to.functionIdentities = map[string]string{"public.greet(name text)": "public.greet(text)"}
+
+		up, err := schemaChanges(from, to)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"DROP ROUTINE public.greet(text);",
+			"CREATE OR REPLACE FUNCTION public.greet(name text)\n RETURNS text\n LANGUAGE sql\nAS $function$SELECT 'hi ' || name$function$;",
+		}, up)
+	})
+}
+
+func TestCommentedObject(t *testing.T) {
+	for target, expected := range map[string]string{
+		"TABLE public.people":                   "table public.people",
+		"MATERIALIZED VIEW public.totals":       "view public.totals",
+		`COLUMN public."my.table".name`:         `table public."my.table"`,
+		"CONSTRAINT pets_pk ON public.pets":     "constraint pets_pk on public.pets",
+		"TRIGGER people_touch ON public.people": "trigger people_touch on public.people",
+		"PROCEDURE public.archive(integer)":     "function public.archive(integer)",
+		"INDEX public.people_name_idx":          "index public.people_name_idx",
+		"FUNCTION public.touch(integer, text)":  "function public.touch(integer, text)",
+	} {
+		require.Equal(t, expected, commentedObject(target), target)
+	}
+}
+
+func TestAlterColumn(t *testing.T) {
+	serial := &dumpedColumn{
+		name: "id", dataType: "integer", notNull: true, defaultValue: "nextval('public.people_id_seq'::regclass)",
+	}
+	identity := &dumpedColumn{name: "id", dataType: "bigint", notNull: true, identity: "a"}
+
+	require.Equal(t, []string{
+		"ALTER TABLE public.people ALTER COLUMN id TYPE bigint;",
+		"ALTER TABLE public.people ALTER COLUMN id DROP DEFAULT;",
+		"ALTER TABLE public.people ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY;",
+	}, alterColumn("public.people", serial, identity))
+	require.Equal(t, []string{
+		"ALTER TABLE public.people ALTER COLUMN id DROP IDENTITY;",
+		"ALTER TABLE public.people ALTER COLUMN id TYPE integer;",
+		"ALTER TABLE public.people ALTER COLUMN id SET DEFAULT nextval('public.people_id_seq'::regclass);",
+	}, alterColumn("public.people", identity, serial))
+}
+
+func TestDropStatement(t *testing.T) {
+	for def, expected := range map[string]string{
+		"CREATE TYPE public.mood AS ENUM ('sad', 'happy');":                        "DROP TYPE public.mood;",
+		"ALTER SEQUENCE public.people_id_seq OWNED BY public.people.id;":           "ALTER SEQUENCE public.people_id_seq OWNED BY NONE;",
+		"CREATE OR REPLACE FUNCTION public.touch()\n RETURNS trigger":              "DROP ROUTINE public.touch();",
+		"ALTER TABLE ONLY public.pets ADD CONSTRAINT pets_pk PRIMARY KEY (id);":    "ALTER TABLE public.pets DROP CONSTRAINT pets_pk;",
+		`CREATE INDEX pets_idx ON "my schema".pets USING btree (id);`:              `DROP INDEX "my schema".pets_idx;`,
+		"CREATE MATERIALIZED VIEW public.totals AS\n SELECT 1\nWITH NO DATA;":      "DROP MATERIALIZED VIEW public.totals;",
+		"CREATE TRIGGER people_touch BEFORE UPDATE ON public.people FOR EACH ROW;": "DROP TRIGGER people_touch ON public.people;",
+		"COMMENT ON TABLE public.people IS 'people';":                              "COMMENT ON TABLE public.people IS NULL;",
+		"GRANT SELECT ON public.people TO reader;":                                 "-- not reverted, revert by hand:\n-- GRANT SELECT ON public.people TO reader;",
+	} {
+		require.Equal(t, expected, dropStatement(schemaObject(def), def), def)
+	}
+
+	require.Equal(
+		t,
+		"DROP ROUTINE public.touch(integer);",
+		dropStatement(
+			"function public.touch(integer)",
+			"CREATE OR REPLACE FUNCTION public.touch(a integer DEFAULT 1)\n RETURNS trigger",
+		),
+	)
+}
+
+func TestCreateDiffMigration(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "diff_source")
+	cfg := m.cfg
+
+	target := *cfg
+	target.Connection.Migration.Database += "_target"
+	err := CreateDatabase(target)
+	require.NoError(t, err)
+	defer func(t *testing.T) {
+		err = DropDatabase(target)
+		require.NoError(t, err)
+	}(t)
+
+	sourceURL, err := cfg.dbURL()
+	require.NoError(t, err)
+	targetURL, err := target.dbURL()
+	require.NoError(t, err)
+
+	exec := func(dbURL, stmnt string) {
+		db, err := sql.Open(pgDriver, dbURL)
+		require.NoError(t, err)
+		defer db.Close()
+		_, err = db.ExecContext(ctx, stmnt)
+		require.NoError(t, err)
+	}
+	exec(sourceURL, `CREATE TABLE public.people (id SERIAL PRIMARY KEY, name TEXT)`)
+	exec(targetURL, `
+CREATE TABLE public.people (id SERIAL PRIMARY KEY, name TEXT NOT NULL DEFAULT 'unknown', age INT);
+CREATE INDEX people_name_idx ON public.people (name);
+CREATE VIEW public.adults AS SELECT id, name FROM public.people WHERE age >= 18;
+`)
+
+	err = CreateDiffMigration(ctx, cfg, "prototype", "", targetURL)
+	require.NoError(t, err)
+	upFiles, err := filepath.Glob(filepath.Join(tempDir, "*_prototype.up.sql"))
+	require.NoError(t, err)
+	require.Len(t, upFiles, 1)
+
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+
+	diff, err := DiffDatabases(ctx, sourceURL, targetURL, trackingTables(cfg))
+	require.NoError(t, err)
+	require.True(t, diff.Empty(), diff)
+
+	_, err = m.Down(ctx, 1)
+	require.NoError(t, err)
+
+	diff, err = DiffDatabases(ctx, sourceURL, targetURL, trackingTables(cfg))
+	require.NoError(t, err)
+	require.False(t, diff.Empty())
+}
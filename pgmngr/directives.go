@@ -0,0 +1,122 @@
+package pgmngr
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const directivePrefix = "pgmngr:"
+
+// directives are the per file options given in the header comments of a
+// migration file, e.g.
+//
+//	-- pgmngr: no-transaction, lock-timeout=5s, environments=staging,prod
+//
+// Options are separated by commas, the values of list options such as
+// environments and requires continue up to the next key=value option.
+type directives struct {
+	NoTransaction    bool
+	LockTimeout      time.Duration
+	StatementTimeout time.Duration
+	// Environments the migration is limited to, all when empty.
+	Environments []string
+	// Requires lists the versions that must be applied before the migration.
+	Requires []int64
+	// Squash marks a baseline replacing every migration up to its version.
+	Squash bool
+}
+
+// parseDirectives reads the directives of the comment lines at the top of a
+// migration file. Parsing stops at the first line that is neither blank nor
+// a comment.
+func parseDirectives(b []byte) (directives, error) {
+	d := directives{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if !strings.HasPrefix(text, "--") {
+			break
+		}
+		text = strings.TrimSpace(strings.TrimPrefix(text, "--"))
+		if !strings.HasPrefix(text, directivePrefix) {
+			continue
+		}
+
+		options := strings.TrimSpace(strings.TrimPrefix(text, directivePrefix))
+		key := ""
+		for _, option := range strings.Split(options, ",") {
+			option = strings.TrimSpace(option)
+			if option == "" {
+				continue
+			}
+
+			value := ""
+			if i := strings.Index(option, "="); i >= 0 {
+				key, value = strings.TrimSpace(option[:i]), strings.TrimSpace(option[i+1:])
+			} else if isListDirective(key) && !isFlagDirective(option) {
+				value = option
+			} else {
+				key = option
+			}
+
+			err := d.set(key, value)
+			if err != nil {
+				return d, NewError(fmt.Errorf("line %v: %v", line, err))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return d, NewError(err)
+	}
+
+	return d, nil
+}
+
+func isListDirective(key string) bool {
+	return key == "environments" || key == "requires"
+}
+
+func isFlagDirective(key string) bool {
+	return key == "no-transaction" || key == "squash"
+}
+
+func (d *directives) set(key, value string) error {
+	var err error
+	switch key {
+	case "no-transaction", "squash":
+		if value != "" {
+			return fmt.Errorf("directive %s does not take a value", key)
+		}
+		d.NoTransaction = d.NoTransaction || key == "no-transaction"
+		d.Squash = d.Squash || key == "squash"
+	case "lock-timeout":
+		d.LockTimeout, err = time.ParseDuration(value)
+	case "statement-timeout":
+		d.StatementTimeout, err = time.ParseDuration(value)
+	case "environments":
+		if value == "" {
+			err = fmt.Errorf("empty environment")
+			break
+		}
+		d.Environments = append(d.Environments, value)
+	case "requires":
+		var version int64
+		version, err = strconv.ParseInt(value, 10, 64)
+		d.Requires = append(d.Requires, version)
+	default:
+		return fmt.Errorf("unknown directive: %s", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for directive %s: %v", key, err)
+	}
+	return nil
+}
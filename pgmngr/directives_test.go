@@ -0,0 +1,59 @@
+package pgmngr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDirectives(t *testing.T) {
+	src := `-- add the index
+-- pgmngr: lock-timeout=5s, statement-timeout=1m
+
+CREATE INDEX foo_bar_idx ON foo (bar);
+-- pgmngr: lock-timeout=10s
+`
+	d, err := parseDirectives([]byte(src))
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, d.LockTimeout)
+	require.Equal(t, time.Minute, d.StatementTimeout)
+
+	d, err = parseDirectives([]byte(
+		"-- pgmngr: no-transaction, environments=staging,prod, requires=1600000001, 1600000002\n",
+	))
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		directives{
+			NoTransaction: true,
+			Environments:  []string{"staging", "prod"},
+			Requires:      []int64{1600000001, 1600000002},
+		},
+		d,
+	)
+
+	d, err = parseDirectives([]byte("-- pgmngr: squash\n"))
+	require.NoError(t, err)
+	require.Equal(t, directives{Squash: true}, d)
+
+	d, err = parseDirectives([]byte("CREATE TABLE foo (id INT);"))
+	require.NoError(t, err)
+	require.Equal(t, directives{}, d)
+
+	_, err = parseDirectives([]byte("-- pgmngr: unknown=1"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "line 1: unknown directive: unknown")
+
+	_, err = parseDirectives([]byte("-- pgmngr: lock-timeout=1s, staging"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown directive: staging")
+
+	_, err = parseDirectives([]byte("-- pgmngr: requires=latest"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid value for directive requires")
+
+	_, err = parseDirectives([]byte("\n-- pgmngr: lock-timeout=soon"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "line 2: invalid value for directive lock-timeout")
+}
@@ -0,0 +1,212 @@
+package pgmngr
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// DriftKind is how an object of the database differs from a schema file.
+type DriftKind string
+
+const (
+	// DriftAdded the object exists in the database but not in the file
+	DriftAdded DriftKind = "added"
+	// DriftRemoved the object exists in the file but not in the database
+	DriftRemoved DriftKind = "removed"
+	// DriftChanged the object exists in both, with different definitions
+	DriftChanged DriftKind = "changed"
+)
+
+// SchemaDrift is an object whose definition in the database does not match
+// the one of the schema file.
+type SchemaDrift struct {
+	Kind   DriftKind `json:"kind"`
+	Object string    `json:"object"`
+	// Expected is the definition of the object in the schema file.
+	Expected string `json:"expected,omitempty"`
+	// Actual is the definition of the object in the database.
+	Actual string `json:"actual,omitempty"`
+}
+
+// schemaName matches an, optionally schema qualified and quoted, name.
+const schemaName = `((?:"(?:[^"]|"")*"|[^\s"(])+)`
+
+// schemaObjectPatterns identify the object defined by a statement of a
+// schema dump, the submatches being its name.
+var schemaObjectPatterns = []struct {
+	kind  string
+	regex *regexp.Regexp
+}{
+	{"schema", regexp.MustCompile(`^CREATE SCHEMA IF NOT EXISTS ` + schemaName)},
+	{"extension", regexp.MustCompile(`^CREATE EXTENSION IF NOT EXISTS ` + schemaName)},
+	{"type", regexp.MustCompile(`^CREATE TYPE ` + schemaName)},
+	{"domain", regexp.MustCompile(`^CREATE DOMAIN ` + schemaName)},
+	{"sequence", regexp.MustCompile(`^CREATE SEQUENCE ` + schemaName)},
+	{"sequence owner", regexp.MustCompile(`^ALTER SEQUENCE ` + schemaName + ` OWNED BY`)},
+	{"function", regexp.MustCompile(`^CREATE OR REPLACE (?:FUNCTION|PROCEDURE) ([^\n]+)`)},
+	{"table", regexp.MustCompile(`^CREATE TABLE ` + schemaName)},
+	{"constraint", regexp.MustCompile(`^ALTER TABLE ONLY ` + schemaName + ` ADD CONSTRAINT ` + schemaName)},
+	{"index", regexp.MustCompile(`^CREATE (?:UNIQUE )?INDEX ` + schemaName + ` ON (?:ONLY )?` + schemaName)},
+	{"view", regexp.MustCompile(`^CREATE (?:MATERIALIZED )?VIEW ` + schemaName)},
+	{"trigger", regexp.MustCompile(`(?s)^CREATE (?:CONSTRAINT )?TRIGGER ` + schemaName + ` .*? ON ` + schemaName)},
+	{"comment on", regexp.MustCompile(
+		`^COMMENT ON ((?:MATERIALIZED )?[A-Z]+ ` + schemaName + `(?:\([^)]*\))?(?: ON ` + schemaName + `)?) IS `,
+	)},
+}
+
+// schemaObject returns the object defined by a statement of a schema dump,
+// e.g. "table public.people", "index public.people_name_idx" or "constraint
+// pets_pk on public.pets". An empty string is returned for the statements
+// configuring the session.
+func schemaObject(stmnt string) string {
+	if strings.HasPrefix(stmnt, "SET ") {
+		return ""
+	}
+
+	kind, names := schemaObjectNames(stmnt)
+	switch kind {
+	case "":
+		// not an object of a dump, it is compared as a whole
+		return stmnt
+	case "constraint":
+		return fmt.Sprintf("constraint %s on %s", names[1], names[0])
+	case "trigger":
+		return fmt.Sprintf("trigger %s on %s", names[0], names[1])
+	case "index":
+		// the index is in the schema of its table
+		return "index " + schemaPrefix(names[1]) + names[0]
+	default:
+		return kind + " " + names[0]
+	}
+}
+
+// schemaObjectNames returns the kind of object defined by a statement of a
+// schema dump and its names, i.e. the submatches of its pattern.
+func schemaObjectNames(stmnt string) (string, []string) {
+	for _, pattern := range schemaObjectPatterns {
+		match := pattern.regex.FindStringSubmatch(stmnt)
+		if match == nil {
+			continue
+		}
+		names := match[1:]
+		for i := range names {
+			names[i] = strings.TrimSpace(names[i])
+		}
+		return pattern.kind, names
+	}
+	return "", nil
+}
+
+// schemaObjects returns the definitions of the objects of a schema dump by
+// object, along with the objects in the order of the dump. The applied
+// migrations listed by the dump are objects too, without a definition.
+func schemaObjects(dump []byte) (map[string]string, []string, error) {
+	src := strings.Replace(string(dump), "\r\n", "\n", -1)
+	stmnts, err := splitStatements(src)
+	if err != nil {
+		return nil, nil, NewError(err)
+	}
+
+	definitions := make(map[string]string)
+	objects := make([]string, 0, len(stmnts))
+	add := func(object, definition string) {
+		if _, ok := definitions[object]; ok {
+			// such as a statement repeated in a file edited by hand
+			for n := 2; ; n++ {
+				numbered := fmt.Sprintf("%s (%v)", object, n)
+				if _, ok := definitions[numbered]; !ok {
+					object = numbered
+					break
+				}
+			}
+		}
+		definitions[object] = definition
+		objects = append(objects, object)
+	}
+
+	for i := range stmnts {
+		object := schemaObject(stmnts[i].SQL)
+		if object == "" {
+			continue
+		}
+		add(object, stmnts[i].SQL+";")
+	}
+
+	versions, err := schemaAppliedVersions([]byte(src))
+	if err != nil {
+		return nil, nil, NewError(err)
+	}
+	for _, version := range versions {
+		add(fmt.Sprint("migration ", version), "")
+	}
+
+	return definitions, objects, nil
+}
+
+// diffSchemas compares two schema dumps object by object, the objects of
+// expected coming first in the order of the dump.
+func diffSchemas(expected, actual []byte) ([]SchemaDrift, error) {
+	expectedDefs, expectedObjects, err := schemaObjects(expected)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	actualDefs, actualObjects, err := schemaObjects(actual)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	drifts := make([]SchemaDrift, 0)
+	for _, object := range expectedObjects {
+		actualDef, ok := actualDefs[object]
+		switch {
+		case !ok:
+			drifts = append(drifts, SchemaDrift{
+				Kind:     DriftRemoved,
+				Object:   object,
+				Expected: expectedDefs[object],
+			})
+		case actualDef != expectedDefs[object]:
+			drifts = append(drifts, SchemaDrift{
+				Kind:     DriftChanged,
+				Object:   object,
+				Expected: expectedDefs[object],
+				Actual:   actualDef,
+			})
+		}
+	}
+	for _, object := range actualObjects {
+		if _, ok := expectedDefs[object]; !ok {
+			drifts = append(drifts, SchemaDrift{
+				Kind:   DriftAdded,
+				Object: object,
+				Actual: actualDefs[object],
+			})
+		}
+	}
+
+	return drifts, nil
+}
+
+// CheckDrift compares the schema of the database with a schema file written
+// by DumpSchema, returning the objects that differ. Both are normalized the
+// same way, so an empty result means the database matches the file.
+func (m *Migrator) CheckDrift(ctx context.Context, filePath string) ([]SchemaDrift, error) {
+	expected, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	actual, err := m.DumpSchema(ctx)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	drifts, err := diffSchemas(expected, []byte(actual))
+	if err != nil {
+		return nil, NewError(err)
+	}
+	return drifts, nil
+}
@@ -0,0 +1,141 @@
+package pgmngr
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaObject(t *testing.T) {
+	for stmnt, expected := range map[string]string{
+		"SET LOCAL check_function_bodies = false":                                 "",
+		"CREATE SCHEMA IF NOT EXISTS audit":                                       "schema audit",
+		"CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public":              "extension pgcrypto",
+		"CREATE TYPE public.mood AS ENUM ('sad', 'happy')":                        "type public.mood",
+		"CREATE SEQUENCE public.people_id_seq AS integer":                         "sequence public.people_id_seq",
+		"ALTER SEQUENCE public.people_id_seq OWNED BY public.people.id":           "sequence owner public.people_id_seq",
+		"CREATE OR REPLACE FUNCTION public.touch(a integer)\n RETURNS trigger":    "function public.touch(a integer)",
+		`CREATE TABLE public."my table" (` + "\n    id integer\n)":                `table public."my table"`,
+		"ALTER TABLE ONLY public.pets ADD CONSTRAINT pets_pk PRIMARY KEY (id)":    "constraint pets_pk on public.pets",
+		"CREATE UNIQUE INDEX people_name_idx ON public.people USING btree (name)": "index public.people_name_idx",
+		"CREATE INDEX people_name_idx ON ONLY audit.people USING btree (name)":    "index audit.people_name_idx",
+		"CREATE VIEW public.happy_people AS\n SELECT 1":                           "view public.happy_people",
+		"CREATE TRIGGER people_touch BEFORE UPDATE ON public.people FOR EACH ROW": "trigger people_touch on public.people",
+		"COMMENT ON TABLE public.people IS 'people''s table'":                     "comment on TABLE public.people",
+		"COMMENT ON FUNCTION public.touch(integer, text) IS 'touch'":              "comment on FUNCTION public.touch(integer, text)",
+		"COMMENT ON CONSTRAINT pets_pk ON public.pets IS 'pk'":                    "comment on CONSTRAINT pets_pk ON public.pets",
+		"GRANT SELECT ON public.people TO reader":                                 "GRANT SELECT ON public.people TO reader",
+	} {
+		require.Equal(t, expected, schemaObject(stmnt), stmnt)
+	}
+}
+
+func TestDiffSchemas(t *testing.T) {
+	expected := []byte(`SET LOCAL check_function_bodies = false;
+
+-- Tables
+
+CREATE TABLE public.people (
+    id integer NOT NULL
+);
+CREATE TABLE public.pets (
+    id integer NOT NULL
+);
+
+-- Indexes
+
+CREATE INDEX people_id_idx ON public.people USING btree (id);
+
+-- Applied migrations
+
+-- pgmngr-applied: 1600000001
+`)
+	actual := []byte(`SET LOCAL check_function_bodies = false;
+
+-- Tables
+
+CREATE TABLE public.people (
+    id integer NOT NULL,
+    name text
+);
+CREATE TABLE public.pets (
+    id integer NOT NULL
+);
+
+-- Indexes
+
+CREATE INDEX people_name_idx ON public.people USING btree (name);
+
+-- Applied migrations
+
+-- pgmngr-applied: 1600000001
+-- pgmngr-applied: 1600000002
+`)
+
+	drifts, err := diffSchemas(expected, expected)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+
+	drifts, err = diffSchemas(expected, actual)
+	require.NoError(t, err)
+	require.Equal(t, []SchemaDrift{
+		{
+			Kind:     DriftChanged,
+			Object:   "table public.people",
+			Expected: "CREATE TABLE public.people (\n    id integer NOT NULL\n);",
+			Actual:   "CREATE TABLE public.people (\n    id integer NOT NULL,\n    name text\n);",
+		},
+		{
+			Kind:     DriftRemoved,
+			Object:   "index public.people_id_idx",
+			Expected: "CREATE INDEX people_id_idx ON public.people USING btree (id);",
+		},
+		{
+			Kind:   DriftAdded,
+			Object: "index public.people_name_idx",
+			Actual: "CREATE INDEX people_name_idx ON public.people USING btree (name);",
+		},
+		{
+			Kind:   DriftAdded,
+			Object: "migration 1600000002",
+		},
+	}, drifts)
+}
+
+func TestMigrator_CheckDrift(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "check_drift")
+
+	writeTestMigration(t, tempDir, 1600000001, "people", `
+CREATE TABLE public.people (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL
+);
+`, "DROP TABLE public.people;")
+
+	_, err := m.Up(ctx)
+	require.NoError(t, err)
+
+	dump, err := m.DumpSchema(ctx)
+	require.NoError(t, err)
+	schemaFile := filepath.Join(tempDir, "structure.sql")
+	err = ioutil.WriteFile(schemaFile, []byte(dump), 0644)
+	require.NoError(t, err)
+
+	drifts, err := m.CheckDrift(ctx, schemaFile)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+
+	// a hotfix applied directly to the database
+	_, err = m.db.ExecContext(ctx, "CREATE INDEX people_name_idx ON public.people (name)")
+	require.NoError(t, err)
+
+	drifts, err = m.CheckDrift(ctx, schemaFile)
+	require.NoError(t, err)
+	require.Len(t, drifts, 1)
+	require.Equal(t, DriftAdded, drifts[0].Kind)
+	require.Equal(t, "index public.people_name_idx", drifts[0].Object)
+}
@@ -0,0 +1,571 @@
+package pgmngr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Migration tools whose history can be imported.
+const (
+	ImportGoose         = "goose"
+	ImportGolangMigrate = "golang-migrate"
+	ImportSQLMigrate    = "sql-migrate"
+	ImportPgmgr         = "pgmgr"
+	ImportRails         = "rails"
+)
+
+// ImportOptions configures Import.
+type ImportOptions struct {
+	// From is the tool the history is imported from, e.g. ImportGoose.
+	From string
+	// Table is the tracking table of the tool, `schema.table` or `table` in
+	// the public schema. The default table of the tool is used when empty.
+	Table string
+	// Directory holds the migration files of the tool, the migration
+	// directory of the Config when empty.
+	Directory string
+	// Archive is the directory the converted files of the tool are moved
+	// to, <migration directory>_archive/<tool> when empty.
+	Archive string
+}
+
+// importArchive returns the default directory the converted files of a tool
+// are moved to, next to the migration directory like squashed files.
+func importArchive(dir, from string) string {
+	dir = filepath.Clean(dir)
+	return filepath.Join(filepath.Dir(dir), filepath.Base(dir)+"_archive", from)
+}
+
+// importFormat describes the tracking table and file naming convention of a
+// migration tool.
+type importFormat struct {
+	table string
+	// query returns the applied versions, as text, from the table given as
+	// its argument.
+	query string
+	// annotation prefixes the lines splitting a single migration file into
+	// its up and down sections, e.g. `-- +goose `.
+	annotation string
+}
+
+var importFormats = map[string]importFormat{
+	ImportGoose: {
+		table: "goose_db_version",
+		// a version is applied when its latest row is
+		query: `SELECT CAST(version_id AS TEXT) FROM %s
+WHERE version_id > 0
+GROUP BY version_id
+HAVING (array_agg(is_applied ORDER BY id DESC))[1]`,
+		annotation: "-- +goose ",
+	},
+	ImportGolangMigrate: {
+		table: "schema_migrations",
+		query: `SELECT CAST(version AS TEXT), dirty FROM %s`,
+	},
+	ImportSQLMigrate: {
+		table:      "gorp_migrations",
+		query:      `SELECT id FROM %s`,
+		annotation: "-- +migrate ",
+	},
+	ImportPgmgr: {
+		table: "schema_migrations",
+		query: `SELECT CAST(version AS TEXT) FROM %s`,
+	},
+	ImportRails: {
+		table: "schema_migrations",
+		query: `SELECT CAST(version AS TEXT) FROM %s`,
+	},
+}
+
+// importedMigration is a migration of another tool converted to the file
+// convention of pgmngr.
+type importedMigration struct {
+	// Raw is the version as recorded by the tool.
+	Raw     string
+	Version int64
+	Name    string
+	// Files are the files of the tool holding the migration.
+	Files         []string
+	Up            []byte
+	Down          []byte
+	HasSQL        bool
+	NoTransaction bool
+}
+
+var importFileRegex = regexp.MustCompile(`^(\d+)(?:_(.*?))?(\.up\.sql|\.down\.sql|\.sql|\.rb|\.go)$`)
+
+// railsTimestampLayout is the layout of the YYYYMMDDHHMMSS versions used by
+// Rails, goose and golang-migrate.
+const railsTimestampLayout = "20060102150405"
+
+// mapImportedVersion maps the version recorded by another tool to a pgmngr
+// version. YYYYMMDDHHMMSS timestamps are converted to Unix timestamps, other
+// numeric versions, e.g. sequential ones, are kept as they are.
+func mapImportedVersion(raw string) (int64, error) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) == len(railsTimestampLayout) {
+		t, err := time.Parse(railsTimestampLayout, raw)
+		if err == nil {
+			return t.Unix(), nil
+		}
+	}
+
+	version, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, NewError(fmt.Errorf("invalid migration version: %s", raw))
+	}
+	if version <= 0 {
+		return 0, NewError(fmt.Errorf("invalid migration version: %s", raw))
+	}
+	return version, nil
+}
+
+// splitAnnotatedMigration splits a goose or sql-migrate file into its up and
+// down sections. Statement markers are dropped as pgmngr splits statements
+// itself.
+func splitAnnotatedMigration(src []byte, annotation string) ([]byte, []byte, bool, error) {
+	var up, down bytes.Buffer
+	var section *bytes.Buffer
+	noTransaction := false
+
+	prefix := strings.TrimSpace(annotation)
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(src)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, prefix) {
+			if section != nil {
+				section.WriteString(line)
+				section.WriteByte('\n')
+			}
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(trimmed, prefix))
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "up":
+			section = &up
+			for _, option := range fields[1:] {
+				if strings.ToLower(option) == "notransaction" {
+					noTransaction = true
+				}
+			}
+		case "down":
+			section = &down
+		case "no":
+			if len(fields) > 1 && strings.ToLower(fields[1]) == "transaction" {
+				noTransaction = true
+			}
+		case "statementbegin", "statementend", "envsub":
+		default:
+			return nil, nil, false, NewError(fmt.Errorf("unknown annotation: %s", trimmed))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, false, NewError(err)
+	}
+
+	return up.Bytes(), down.Bytes(), noTransaction, nil
+}
+
+// readImportedMigrations reads the migration files of a tool from dir,
+// keyed by the version the tool records.
+func readImportedMigrations(from, dir string) (map[string]*importedMigration, error) {
+	format := importFormats[from]
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	migrations := make(map[string]*importedMigration)
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		matches := importFileRegex.FindStringSubmatch(info.Name())
+		if matches == nil {
+			continue
+		}
+		raw, name, ext := matches[1], matches[2], matches[3]
+
+		im, ok := migrations[raw]
+		if !ok {
+			version, err := mapImportedVersion(raw)
+			if err != nil {
+				return nil, NewError(err)
+			}
+			im = &importedMigration{Raw: raw, Version: version}
+			migrations[raw] = im
+		}
+
+		if strings.HasSuffix(name, ".no_txn") {
+			name = strings.TrimSuffix(name, ".no_txn")
+			im.NoTransaction = true
+		}
+		if im.Name == "" {
+			im.Name = name
+		}
+
+		filePath := filepath.Join(dir, info.Name())
+		im.Files = append(im.Files, filePath)
+		if ext == ".rb" || ext == ".go" {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		switch {
+		case format.annotation != "" && ext == ".sql":
+			up, down, noTransaction, err := splitAnnotatedMigration(b, format.annotation)
+			if err != nil {
+				return nil, NewError(fmt.Errorf("%s: %v", filePath, err))
+			}
+			im.Up, im.Down, im.HasSQL = up, down, true
+			im.NoTransaction = im.NoTransaction || noTransaction
+		case format.annotation == "" && ext == ".up.sql":
+			im.Up, im.HasSQL = b, true
+		case format.annotation == "" && ext == ".down.sql":
+			im.Down = b
+		}
+	}
+
+	versions := make(map[int64]string)
+	for raw, im := range migrations {
+		if other, ok := versions[im.Version]; ok {
+			return nil, NewError(
+				fmt.Errorf("versions: %s and %s both map to version: %v", other, raw, im.Version),
+			)
+		}
+		versions[im.Version] = raw
+		if im.Name == "" {
+			im.Name = "migration"
+		}
+	}
+
+	return migrations, nil
+}
+
+// files returns the pgmngr up and down file paths of the migration in dir.
+func (im *importedMigration) files(dir string) (string, string) {
+	prefix := filepath.Join(dir, fmt.Sprint(im.Version, "_", im.Name))
+	return prefix + ".up.sql", prefix + ".down.sql"
+}
+
+// upFile returns the contents of the up file, running outside of a
+// transaction through a directive when needed.
+func (im *importedMigration) upFile() []byte {
+	d, err := parseDirectives(im.Up)
+	if !im.NoTransaction || (err == nil && d.NoTransaction) {
+		return im.Up
+	}
+	return append([]byte("-- "+directivePrefix+" no-transaction\n"), im.Up...)
+}
+
+// write writes the migration to dir following the pgmngr file convention.
+func (im *importedMigration) write(dir string) error {
+	upPath, downPath := im.files(dir)
+	for path, b := range map[string][]byte{upPath: im.upFile(), downPath: im.Down} {
+		err := ioutil.WriteFile(path, b, 0644)
+		if err != nil {
+			return NewError(err)
+		}
+	}
+	return nil
+}
+
+// parseImportTable splits `schema.table` into its parts, the schema being
+// public when not given.
+func parseImportTable(table string) (string, string) {
+	if i := strings.Index(table, "."); i >= 0 {
+		return table[:i], table[i+1:]
+	}
+	return "public", table
+}
+
+// getImportedVersions returns the versions applied by the tool, as the tool
+// records them.
+func getImportedVersions(ctx context.Context, q execer, from, schema, table string, migrations map[string]*importedMigration) ([]string, error) {
+	format := importFormats[from]
+	rows, err := q.QueryContext(
+		ctx,
+		fmt.Sprintf(format.query, pq.QuoteIdentifier(schema)+"."+pq.QuoteIdentifier(table)),
+	)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	defer rows.Close()
+
+	applied := make([]string, 0)
+	for rows.Next() {
+		var raw string
+		switch from {
+		case ImportGolangMigrate:
+			// a single row holds the latest applied version
+			var dirty bool
+			err = rows.Scan(&raw, &dirty)
+			if err != nil {
+				return nil, NewError(err)
+			}
+			if dirty {
+				return nil, NewError(
+					fmt.Errorf("version: %s is dirty, fix the database before importing", raw),
+				)
+			}
+			latest, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return nil, NewError(err)
+			}
+			for r := range migrations {
+				v, err := strconv.ParseInt(r, 10, 64)
+				if err == nil && v <= latest {
+					applied = append(applied, r)
+				}
+			}
+		case ImportSQLMigrate:
+			// the id is the file name of the migration
+			err = rows.Scan(&raw)
+			if err != nil {
+				return nil, NewError(err)
+			}
+			matches := importFileRegex.FindStringSubmatch(raw)
+			if matches == nil {
+				return nil, NewError(fmt.Errorf("unexpected migration id: %s", raw))
+			}
+			applied = append(applied, matches[1])
+		default:
+			err = rows.Scan(&raw)
+			if err != nil {
+				return nil, NewError(err)
+			}
+			applied = append(applied, strings.TrimSpace(raw))
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, NewError(err)
+	}
+
+	return applied, nil
+}
+
+// Import populates the schema migrations table with the history recorded by
+// another migration tool. The migration files of the tool are converted to
+// the pgmngr file convention in the migration directory, versions given as
+// YYYYMMDDHHMMSS timestamps being mapped to Unix timestamps. Migrations the
+// tool applied are marked as applied without being run. Files that cannot be
+// converted, e.g. Rails or goose Go migrations, are recorded as applied
+// without a file. The converted files are moved to the archive directory.
+func (m *Migrator) Import(ctx context.Context, opts ImportOptions) ([]MigrationResult, error) {
+	format, ok := importFormats[opts.From]
+	if !ok {
+		tools := make([]string, 0, len(importFormats))
+		for tool := range importFormats {
+			tools = append(tools, tool)
+		}
+		sort.Strings(tools)
+		return nil, NewError(
+			fmt.Errorf("unknown migration tool: %s, expected one of: %s", opts.From, strings.Join(tools, ", ")),
+		)
+	}
+
+	table := opts.Table
+	if table == "" {
+		table = format.table
+	}
+	schema, table := parseImportTable(table)
+	if schema == m.cfg.Migration.Table.Schema && table == m.cfg.Migration.Table.Name {
+		return nil, NewError(
+			fmt.Errorf(
+				"table: %s.%s is both the %s and the pgmngr tracking table, configure another migration table, e.g. `pgmngr --set migration.table.name=pgmngr_migrations migration import --from %s`",
+				schema,
+				table,
+				opts.From,
+				opts.From,
+			),
+		)
+	}
+
+	dir := opts.Directory
+	if dir == "" {
+		dir = m.cfg.Migration.Directory
+	}
+	migrations, err := readImportedMigrations(opts.From, dir)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	return m.migrate(ctx, func(conn *sql.Conn) ([]MigrationResult, error) {
+		exists := false
+		err := conn.QueryRowContext(ctx, stmntSchemaMigrationTableExists, schema, table).Scan(&exists)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		if !exists {
+			return nil, NewError(fmt.Errorf("table: %s.%s of %s does not exist", schema, table, opts.From))
+		}
+
+		importedVersions, err := getImportedVersions(ctx, conn, opts.From, schema, table, migrations)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		// convert the files first, so an import can be run again when
+		// recording the history fails
+		written := make(map[string]bool)
+		for _, im := range migrations {
+			if !im.HasSQL {
+				continue
+			}
+			err = im.write(m.cfg.Migration.Directory)
+			if err != nil {
+				return nil, NewError(err)
+			}
+			upPath, downPath := im.files(m.cfg.Migration.Directory)
+			written[upPath], written[downPath] = true, true
+		}
+
+		applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		recorded := make(map[int64]bool)
+		for i := range applied {
+			recorded[applied[i].Version] = true
+		}
+
+		plan := make([]PlannedMigration, 0)
+		for _, raw := range importedVersions {
+			im, ok := migrations[raw]
+			if !ok {
+				version, err := mapImportedVersion(raw)
+				if err != nil {
+					return nil, NewError(err)
+				}
+				im = &importedMigration{Raw: raw, Version: version}
+			}
+			if recorded[im.Version] {
+				continue
+			}
+			recorded[im.Version] = true
+
+			pm := PlannedMigration{
+				Version:     im.Version,
+				Name:        im.Name,
+				Transaction: !im.NoTransaction,
+			}
+			if im.HasSQL {
+				pm.File, _ = im.files(m.cfg.Migration.Directory)
+				pm.Checksum = checksum(im.upFile())
+			} else if len(im.Files) > 0 {
+				pm.File = im.Files[0]
+			}
+			plan = append(plan, pm)
+		}
+		sort.Slice(
+			plan,
+			func(i, j int) bool {
+				return plan[i].Version < plan[j].Version
+			},
+		)
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		results := make([]MigrationResult, 0)
+		for i := range plan {
+			err = m.recordMigration(ctx, tx, plan[i], plan[i].Checksum, 0)
+			if err != nil {
+				tx.Rollback()
+				return nil, NewError(err)
+			}
+			results = append(results, MigrationResult{PlannedMigration: plan[i], Type: Forward})
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		// the files of the tool are only moved once the history is recorded,
+		// and only out of the migration directory where they would not
+		// follow the pgmngr file convention
+		if sameDirectory(dir, m.cfg.Migration.Directory) {
+			archive := opts.Archive
+			if archive == "" {
+				archive = importArchive(m.cfg.Migration.Directory, opts.From)
+			}
+			archived, err := archiveImportedFiles(migrations, written, archive)
+			if err != nil {
+				return results, NewError(err)
+			}
+			if archived {
+				m.notify(MigrationEvent{Kind: EventFilesArchived, File: archive})
+			}
+		}
+
+		for i := range results {
+			m.notify(MigrationEvent{
+				Kind:    EventMigrationImported,
+				Version: results[i].Version,
+				File:    results[i].File,
+			})
+		}
+
+		return results, nil
+	})
+}
+
+func sameDirectory(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// archiveImportedFiles moves the converted files of a tool, the files written
+// in the pgmngr convention excepted, to the archive directory. It returns
+// true when files were moved.
+func archiveImportedFiles(migrations map[string]*importedMigration, written map[string]bool, archive string) (bool, error) {
+	archived := false
+	for _, im := range migrations {
+		if !im.HasSQL {
+			continue
+		}
+		for _, filePath := range im.Files {
+			if written[filePath] {
+				continue
+			}
+			if !archived {
+				err := os.MkdirAll(archive, 0755)
+				if err != nil {
+					return false, NewError(err)
+				}
+				archived = true
+			}
+			err := os.Rename(filePath, filepath.Join(archive, filepath.Base(filePath)))
+			if err != nil {
+				return archived, NewError(err)
+			}
+		}
+	}
+	return archived, nil
+}
@@ -0,0 +1,149 @@
+package pgmngr
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapImportedVersion(t *testing.T) {
+	version, err := mapImportedVersion("20200913122640")
+	require.NoError(t, err)
+	require.Equal(t, int64(1600000000), version)
+
+	version, err = mapImportedVersion("3")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), version)
+
+	version, err = mapImportedVersion("1600000000")
+	require.NoError(t, err)
+	require.Equal(t, int64(1600000000), version)
+
+	_, err = mapImportedVersion("0")
+	require.Error(t, err)
+
+	_, err = mapImportedVersion("v1")
+	require.Error(t, err)
+}
+
+func TestImportArchive(t *testing.T) {
+	require.Equal(
+		t,
+		filepath.Join("db", "migrations_archive", ImportGoose),
+		importArchive(filepath.Join("db", "migrations")+"/", ImportGoose),
+	)
+}
+
+func TestSplitAnnotatedMigration(t *testing.T) {
+	goose := `-- +goose NO TRANSACTION
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX CONCURRENTLY users_email_idx ON users (email);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP INDEX users_email_idx;
+`
+	up, down, noTransaction, err := splitAnnotatedMigration([]byte(goose), importFormats[ImportGoose].annotation)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE INDEX CONCURRENTLY users_email_idx ON users (email);\n\n", string(up))
+	require.Equal(t, "DROP INDEX users_email_idx;\n", string(down))
+	require.True(t, noTransaction)
+
+	sqlMigrate := `-- +migrate Up
+CREATE TABLE users (id INT);
+-- +migrate Down
+DROP TABLE users;
+`
+	up, down, noTransaction, err = splitAnnotatedMigration([]byte(sqlMigrate), importFormats[ImportSQLMigrate].annotation)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE users (id INT);\n", string(up))
+	require.Equal(t, "DROP TABLE users;\n", string(down))
+	require.False(t, noTransaction)
+
+	_, _, _, err = splitAnnotatedMigration([]byte("-- +migrate Sideways\n"), importFormats[ImportSQLMigrate].annotation)
+	require.Error(t, err)
+}
+
+func TestReadImportedMigrations(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "import_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"20200913122640_create_users.sql": "-- +goose Up\nCREATE TABLE users (id INT);\n-- +goose Down\nDROP TABLE users;\n",
+		"20200913122641_backfill.go":      "package migrations\n",
+		"README.md":                       "not a migration",
+	}
+	for name, contents := range files {
+		err = ioutil.WriteFile(filepath.Join(tempDir, name), []byte(contents), 0644)
+		require.NoError(t, err)
+	}
+
+	migrations, err := readImportedMigrations(ImportGoose, tempDir)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(migrations))
+
+	im := migrations["20200913122640"]
+	require.Equal(t, int64(1600000000), im.Version)
+	require.Equal(t, "create_users", im.Name)
+	require.True(t, im.HasSQL)
+	require.Equal(t, "CREATE TABLE users (id INT);\n", string(im.Up))
+
+	upPath, downPath := im.files(tempDir)
+	require.Equal(t, filepath.Join(tempDir, "1600000000_create_users.up.sql"), upPath)
+	require.Equal(t, filepath.Join(tempDir, "1600000000_create_users.down.sql"), downPath)
+
+	require.False(t, migrations["20200913122641"].HasSQL)
+
+	im = &importedMigration{Up: []byte("SELECT 1;\n"), NoTransaction: true}
+	d, err := parseDirectives(im.upFile())
+	require.NoError(t, err)
+	require.True(t, d.NoTransaction)
+}
+
+func TestMigrator_Import(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "import")
+
+	files := map[string]string{
+		"20200913122640_create_a.sql": "-- +goose Up\nCREATE TABLE public.import_a();\n-- +goose Down\nDROP TABLE public.import_a;\n",
+		"20200913122740_create_b.sql": "-- +goose Up\nCREATE TABLE public.import_b();\n-- +goose Down\nDROP TABLE public.import_b;\n",
+	}
+	for name, contents := range files {
+		err := ioutil.WriteFile(filepath.Join(tempDir, name), []byte(contents), 0644)
+		require.NoError(t, err)
+	}
+
+	_, err := m.db.ExecContext(ctx, `
+CREATE TABLE public.goose_db_version (
+  id SERIAL PRIMARY KEY,
+  version_id BIGINT NOT NULL,
+  is_applied BOOLEAN NOT NULL,
+  tstamp TIMESTAMP DEFAULT NOW()
+);
+INSERT INTO public.goose_db_version (version_id, is_applied) VALUES (0, true), (20200913122640, true);
+CREATE TABLE public.import_a();
+`)
+	require.NoError(t, err)
+
+	results, err := m.Import(ctx, ImportOptions{From: ImportGoose})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(results))
+	require.Equal(t, int64(1600000000), results[0].Version)
+
+	_, err = os.Stat(filepath.Join(tempDir, "20200913122640_create_a.sql"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(importArchive(tempDir, ImportGoose), "20200913122640_create_a.sql"))
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir + "_archive")
+
+	results, err = m.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(results))
+	require.Equal(t, int64(1600000060), results[0].Version)
+}
@@ -0,0 +1,541 @@
+package pgmngr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ParaServices/pgmngr/version"
+)
+
+// LintSeverity is how a lint rule reports the operations it flags.
+type LintSeverity string
+
+const (
+	// LintError the finding fails the lint
+	LintError LintSeverity = "error"
+	// LintWarning the finding is reported without failing the lint
+	LintWarning LintSeverity = "warning"
+	// LintOff the rule is disabled
+	LintOff LintSeverity = "off"
+)
+
+// LintFinding is an operation of a migration file flagged by a lint rule.
+type LintFinding struct {
+	Rule     string       `json:"rule"`
+	Severity LintSeverity `json:"severity"`
+	File     string       `json:"file"`
+	// Line is the line the statement starts on, 0 for the whole file.
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// LintFindings is a list of findings ordered by file and line.
+type LintFindings []LintFinding
+
+// Errors returns the findings failing the lint.
+func (f LintFindings) Errors() LintFindings {
+	errs := make(LintFindings, 0)
+	for i := range f {
+		if f[i].Severity == LintError {
+			errs = append(errs, f[i])
+		}
+	}
+	return errs
+}
+
+// lintRule flags operations known to lock or rewrite big tables. Rules check
+// either every statement of a file, or the file as a whole.
+type lintRule struct {
+	id          string
+	severity    LintSeverity
+	description string
+	statement   func(f *lintedFile, sql string) string
+	file        func(f *lintedFile) string
+}
+
+// lintedFile is the migration file being linted.
+type lintedFile struct {
+	src MigrationSource
+	pm  PlannedMigration
+	// created are the tables created by the statements before the one
+	// being linted, which are too new to be locked for long.
+	created map[string]bool
+}
+
+var (
+	lintCreateTableRegex = regexp.MustCompile(
+		`(?is)^CREATE\s+(?:(?:GLOBAL|LOCAL)\s+)?(?:(?:TEMP|TEMPORARY|UNLOGGED)\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([^\s(]+)`,
+	)
+	lintCreateIndexRegex = regexp.MustCompile(
+		`(?is)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+(CONCURRENTLY\s+)?.*?\sON\s+(?:ONLY\s+)?([^\s(]+)`,
+	)
+	lintConcurrentlyRegex = regexp.MustCompile(
+		`(?is)^(?:CREATE\s+(?:UNIQUE\s+)?INDEX|DROP\s+INDEX|REINDEX\s.*?)\s+CONCURRENTLY\b`,
+	)
+	lintAlterTableRegex = regexp.MustCompile(
+		`(?is)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?([^\s]+)\s+(.*)$`,
+	)
+	lintVolatileDefaultRegex = regexp.MustCompile(
+		`(?is)\bADD\s+(?:COLUMN\s+)?(?:IF\s+NOT\s+EXISTS\s+)?\S+\s+(?:(?:small|big)?serial[248]?\b|[^,]*?\bDEFAULT\b[^,]*?\b` +
+			`(?:random|gen_random_uuid|uuid_generate_v1|uuid_generate_v1mc|uuid_generate_v4|clock_timestamp|timeofday|nextval)\s*\()`,
+	)
+	lintAlterTypeRegex     = regexp.MustCompile(`(?is)\bALTER\s+(?:COLUMN\s+)?\S+\s+(?:SET\s+DATA\s+)?TYPE\b`)
+	lintAddConstraintRegex = regexp.MustCompile(
+		`(?is)\bADD\s+(?:CONSTRAINT\s+\S+\s+)?(FOREIGN\s+KEY|CHECK|PRIMARY\s+KEY|UNIQUE|EXCLUDE)\b`,
+	)
+	lintNotValidRegex   = regexp.MustCompile(`(?is)\bNOT\s+VALID\b`)
+	lintUsingIndexRegex = regexp.MustCompile(`(?is)\bUSING\s+INDEX\b`)
+	lintDropColumnRegex = regexp.MustCompile(`(?is)\bDROP\s+(?:COLUMN\s+)?(?:IF\s+EXISTS\s+)?(\S+)`)
+	lintDropTableRegex  = regexp.MustCompile(`(?is)^DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?([^\s;]+)`)
+	lintRenameRegex     = regexp.MustCompile(`(?is)^ALTER\s+\S+.*?\sRENAME\s`)
+)
+
+// alterTable returns the table and actions of an ALTER TABLE statement on a
+// table not created by the file, or an empty table otherwise.
+func (f *lintedFile) alterTable(sql string) (string, string) {
+	match := lintAlterTableRegex.FindStringSubmatch(sql)
+	if match == nil || f.created[lintTableName(match[1])] {
+		return "", ""
+	}
+	return match[1], match[2]
+}
+
+// lintTableName normalizes a table name so the names of the statements of a
+// file can be compared.
+func lintTableName(name string) string {
+	name = strings.ToLower(strings.Replace(name, `"`, "", -1))
+	return strings.TrimPrefix(name, "public.")
+}
+
+var lintRules = []lintRule{
+	{
+		id:          "create-index-not-concurrently",
+		severity:    LintError,
+		description: "CREATE INDEX without CONCURRENTLY blocks writes to the table while the index is built",
+		statement: func(f *lintedFile, sql string) string {
+			match := lintCreateIndexRegex.FindStringSubmatch(sql)
+			if match == nil || match[1] != "" || f.created[lintTableName(match[2])] {
+				return ""
+			}
+			return fmt.Sprintf("index on %s is not created CONCURRENTLY, which blocks writes to the table", match[2])
+		},
+	},
+	{
+		id:          "concurrently-in-transaction",
+		severity:    LintError,
+		description: "CONCURRENTLY cannot run inside a transaction",
+		statement: func(f *lintedFile, sql string) string {
+			if !f.pm.Transaction || !lintConcurrentlyRegex.MatchString(sql) {
+				return ""
+			}
+			return "CONCURRENTLY cannot run inside a transaction, use a .no_txn file or the no-transaction directive"
+		},
+	},
+	{
+		id:          "add-column-volatile-default",
+		severity:    LintError,
+		description: "ADD COLUMN with a volatile DEFAULT rewrites the table",
+		statement: func(f *lintedFile, sql string) string {
+			table, actions := f.alterTable(sql)
+			if table == "" || !lintVolatileDefaultRegex.MatchString(actions) {
+				return ""
+			}
+			return fmt.Sprintf("column added to %s with a volatile default, which rewrites the table", table)
+		},
+	},
+	{
+		id:          "alter-column-type",
+		severity:    LintError,
+		description: "ALTER COLUMN TYPE rewrites the table unless the types are binary compatible",
+		statement: func(f *lintedFile, sql string) string {
+			table, actions := f.alterTable(sql)
+			if table == "" || !lintAlterTypeRegex.MatchString(actions) {
+				return ""
+			}
+			return fmt.Sprintf("column type of %s changed, which may rewrite the table", table)
+		},
+	},
+	{
+		id:          "add-constraint-not-valid",
+		severity:    LintError,
+		description: "ADD CONSTRAINT without NOT VALID scans the table while holding a lock",
+		statement: func(f *lintedFile, sql string) string {
+			table, actions := f.alterTable(sql)
+			if table == "" {
+				return ""
+			}
+			match := lintAddConstraintRegex.FindStringSubmatch(actions)
+			if match == nil {
+				return ""
+			}
+			kind := strings.ToUpper(strings.Join(strings.Fields(match[1]), " "))
+			switch kind {
+			case "FOREIGN KEY", "CHECK":
+				if lintNotValidRegex.MatchString(actions) {
+					return ""
+				}
+				return fmt.Sprintf(
+					"%s constraint added to %s without NOT VALID, add it NOT VALID then VALIDATE CONSTRAINT", kind, table,
+				)
+			default:
+				if lintUsingIndexRegex.MatchString(actions) {
+					return ""
+				}
+				return fmt.Sprintf(
+					"%s constraint added to %s builds an index, create it CONCURRENTLY then add the constraint USING INDEX",
+					kind, table,
+				)
+			}
+		},
+	},
+	{
+		id:          "drop-column",
+		severity:    LintWarning,
+		description: "DROP COLUMN breaks the code still reading the column",
+		statement: func(f *lintedFile, sql string) string {
+			table, actions := f.alterTable(sql)
+			if table == "" {
+				return ""
+			}
+			for _, match := range lintDropColumnRegex.FindAllStringSubmatch(actions, -1) {
+				switch strings.ToUpper(match[1]) {
+				case "CONSTRAINT", "DEFAULT", "NOT", "IDENTITY", "EXPRESSION":
+					continue
+				}
+				return fmt.Sprintf("column %s of %s dropped, make sure the code no longer uses it", match[1], table)
+			}
+			return ""
+		},
+	},
+	{
+		id:          "drop-table",
+		severity:    LintWarning,
+		description: "DROP TABLE breaks the code still reading the table",
+		statement: func(f *lintedFile, sql string) string {
+			match := lintDropTableRegex.FindStringSubmatch(sql)
+			if match == nil || f.created[lintTableName(match[1])] {
+				return ""
+			}
+			return fmt.Sprintf("table %s dropped, make sure the code no longer uses it", match[1])
+		},
+	},
+	{
+		id:          "rename",
+		severity:    LintWarning,
+		description: "RENAME breaks the code using the previous name",
+		statement: func(f *lintedFile, sql string) string {
+			if !lintRenameRegex.MatchString(sql) {
+				return ""
+			}
+			return "object renamed, which breaks the code using the previous name while it is deployed"
+		},
+	},
+	{
+		id:          "missing-down",
+		severity:    LintWarning,
+		description: "the migration has no .down.sql file to roll it back",
+		file: func(f *lintedFile) string {
+			if f.pm.Repeatable || !isUpMigrationRegex.MatchString(f.pm.File) {
+				return ""
+			}
+			down := strings.TrimSuffix(f.pm.File, ".up.sql") + ".down.sql"
+			if _, err := f.src.ReadFile(down); err == nil {
+				return ""
+			}
+			return fmt.Sprintf("%s not found, the migration cannot be rolled back", filepath.Base(down))
+		},
+	},
+}
+
+// lintSuppressPrefix starts the comments suppressing lint rules, either
+// for the statement following the comment, or for the whole file:
+//
+//	-- pgmngr-lint: disable drop-column, rename
+//	-- pgmngr-lint: disable-file missing-down
+const lintSuppressPrefix = "pgmngr-lint:"
+
+// lintSuppressions holds the rules suppressed by the comments of a file.
+type lintSuppressions struct {
+	file  map[string]bool
+	lines map[int]map[string]bool
+}
+
+func (s lintSuppressions) suppressed(rule string, from, to int) bool {
+	if s.file[rule] || s.file["all"] {
+		return true
+	}
+	for line := from; line <= to; line++ {
+		if s.lines[line][rule] || s.lines[line]["all"] {
+			return true
+		}
+	}
+	return false
+}
+
+func parseLintSuppressions(src string, severities map[string]LintSeverity) (lintSuppressions, error) {
+	s := lintSuppressions{file: make(map[string]bool), lines: make(map[int]map[string]bool)}
+	for i, line := range strings.Split(src, "\n") {
+		j := strings.Index(line, "--")
+		if j < 0 {
+			continue
+		}
+		text := strings.TrimSpace(line[j+2:])
+		if !strings.HasPrefix(text, lintSuppressPrefix) {
+			continue
+		}
+
+		fields := strings.Fields(strings.Replace(strings.TrimPrefix(text, lintSuppressPrefix), ",", " ", -1))
+		if len(fields) < 2 || (fields[0] != "disable" && fields[0] != "disable-file") {
+			return s, NewError(
+				fmt.Errorf("line %v: expected `-- %s disable rule` or `-- %s disable-file rule`", i+1, lintSuppressPrefix, lintSuppressPrefix),
+			)
+		}
+		rules := make(map[string]bool)
+		for _, rule := range fields[1:] {
+			if _, ok := severities[rule]; !ok && rule != "all" {
+				return s, NewError(fmt.Errorf("line %v: unknown lint rule: %s", i+1, rule))
+			}
+			rules[rule] = true
+		}
+
+		if fields[0] == "disable-file" {
+			for rule := range rules {
+				s.file[rule] = true
+			}
+			continue
+		}
+		s.lines[i+1] = rules
+	}
+	return s, nil
+}
+
+// lintSeverities returns the severity of every rule, as configured.
+func lintSeverities(cfg *Config) (map[string]LintSeverity, error) {
+	severities := make(map[string]LintSeverity)
+	for _, rule := range lintRules {
+		severities[rule.id] = rule.severity
+	}
+	for id, severity := range cfg.Migration.Lint.Rules {
+		if _, ok := severities[id]; !ok {
+			return nil, NewError(fmt.Errorf("unknown lint rule: %s", id))
+		}
+		switch severity {
+		case LintError, LintWarning, LintOff:
+		default:
+			return nil, NewError(
+				fmt.Errorf("invalid severity: %s for lint rule: %s, expected error, warning or off", severity, id),
+			)
+		}
+		severities[id] = severity
+	}
+	return severities, nil
+}
+
+// lintFile checks the statements of a migration file against the rules.
+func lintFile(src MigrationSource, pm PlannedMigration, severities map[string]LintSeverity) (LintFindings, error) {
+	findings := make(LintFindings, 0)
+	if isGoMigrationFile(pm.File) {
+		return findings, nil
+	}
+
+	b, err := src.ReadFile(pm.File)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	stmnts, err := splitStatements(string(b))
+	if err != nil {
+		return nil, NewError(fmt.Errorf("%s: %v", pm.File, err))
+	}
+	suppressions, err := parseLintSuppressions(string(b), severities)
+	if err != nil {
+		return nil, NewError(fmt.Errorf("%s: %v", pm.File, err))
+	}
+
+	f := &lintedFile{src: src, pm: pm, created: make(map[string]bool)}
+	for _, rule := range lintRules {
+		if rule.file == nil || severities[rule.id] == LintOff || suppressions.suppressed(rule.id, 0, -1) {
+			continue
+		}
+		if message := rule.file(f); message != "" {
+			findings = append(findings, LintFinding{
+				Rule:     rule.id,
+				Severity: severities[rule.id],
+				File:     pm.File,
+				Message:  message,
+			})
+		}
+	}
+
+	// a suppression comment applies to the statement following it, or to
+	// the one on the same line
+	previousEnd := 0
+	for _, stmnt := range stmnts {
+		end := stmnt.Line + strings.Count(stmnt.SQL, "\n")
+		for _, rule := range lintRules {
+			if rule.statement == nil || severities[rule.id] == LintOff {
+				continue
+			}
+			if suppressions.suppressed(rule.id, previousEnd+1, end) {
+				continue
+			}
+			if message := rule.statement(f, stmnt.SQL); message != "" {
+				findings = append(findings, LintFinding{
+					Rule:     rule.id,
+					Severity: severities[rule.id],
+					File:     pm.File,
+					Line:     stmnt.Line,
+					Message:  message,
+				})
+			}
+		}
+		if match := lintCreateTableRegex.FindStringSubmatch(stmnt.SQL); match != nil {
+			f.created[lintTableName(match[1])] = true
+		}
+		previousEnd = end
+	}
+
+	return findings, nil
+}
+
+// Lint checks the pending migrations for operations known to lock or rewrite
+// big tables.
+func (m *Migrator) Lint(ctx context.Context) (LintFindings, error) {
+	plan, err := m.Plan(ctx)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	return lintMigrations(m.cfg, m.source(), append(plan.Migrations, plan.Repeatable...))
+}
+
+// LintFiles checks the given migration files like Migrator.Lint, without
+// connecting to the database.
+func LintFiles(cfg *Config, files []string) (LintFindings, error) {
+	src := NewDirectorySource(cfg.Migration.Directory)
+	pms := make([]PlannedMigration, 0, len(files))
+	for _, file := range files {
+		var version int64
+		if !isRepeatableMigrationFile(file) {
+			versionStr, err := getVersionFromFileName(filepath.Base(file))
+			if err != nil {
+				return nil, NewError(fmt.Errorf("%s: %v", file, err))
+			}
+			version, err = strconv.ParseInt(versionStr, 10, 64)
+			if err != nil {
+				return nil, NewError(err)
+			}
+		}
+		pm, err := newPlannedMigration(src, version, file)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		pms = append(pms, pm)
+	}
+	return lintMigrations(cfg, src, pms)
+}
+
+func lintMigrations(cfg *Config, src MigrationSource, pms []PlannedMigration) (LintFindings, error) {
+	severities, err := lintSeverities(cfg)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	findings := make(LintFindings, 0)
+	for i := range pms {
+		fileFindings, err := lintFile(src, pms[i], severities)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		findings = append(findings, fileFindings...)
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return findings, nil
+}
+
+// SARIF returns the findings as a SARIF 2.1.0 log, the format code scanning
+// tools read.
+func (f LintFindings) SARIF() ([]byte, error) {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type location struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region *struct {
+				StartLine int `json:"startLine"`
+			} `json:"region,omitempty"`
+		} `json:"physicalLocation"`
+	}
+	type result struct {
+		RuleID    string     `json:"ruleId"`
+		Level     string     `json:"level"`
+		Message   message    `json:"message"`
+		Locations []location `json:"locations"`
+	}
+	type rule struct {
+		ID               string  `json:"id"`
+		ShortDescription message `json:"shortDescription"`
+	}
+
+	rules := make([]rule, 0, len(lintRules))
+	for _, r := range lintRules {
+		rules = append(rules, rule{ID: r.id, ShortDescription: message{Text: r.description}})
+	}
+
+	results := make([]result, 0, len(f))
+	for i := range f {
+		var loc location
+		loc.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(f[i].File)
+		if f[i].Line > 0 {
+			loc.PhysicalLocation.Region = &struct {
+				StartLine int `json:"startLine"`
+			}{StartLine: f[i].Line}
+		}
+		results = append(results, result{
+			RuleID:    f[i].Rule,
+			Level:     string(f[i].Severity),
+			Message:   message{Text: f[i].Message},
+			Locations: []location{loc},
+		})
+	}
+
+	type driver struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+		Rules   []rule `json:"rules"`
+	}
+	type run struct {
+		Tool struct {
+			Driver driver `json:"driver"`
+		} `json:"tool"`
+		Results []result `json:"results"`
+	}
+	r := run{Results: results}
+	r.Tool.Driver = driver{Name: "pgmngr", Version: version.AppRevisionOrTag(), Rules: rules}
+
+	b, err := json.Marshal(struct {
+		Version string `json:"version"`
+		Schema  string `json:"$schema"`
+		Runs    []run  `json:"runs"`
+	}{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []run{r},
+	})
+	if err != nil {
+		return nil, NewError(err)
+	}
+	return b, nil
+}
@@ -0,0 +1,168 @@
+package pgmngr
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func lintRuleIDs(findings LintFindings) []string {
+	ids := make([]string, 0, len(findings))
+	for i := range findings {
+		ids = append(ids, findings[i].Rule)
+	}
+	return ids
+}
+
+func TestLintFiles(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "migrations_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	cfg := testConfig(t)
+	cfg.Migration.Directory = tempDir
+
+	lint := func(up string) LintFindings {
+		writeTestMigration(t, tempDir, 1600000001, "lint", up, "SELECT 1;")
+		findings, err := LintFiles(cfg, []string{filepath.Join(tempDir, "1600000001_lint.up.sql")})
+		require.NoError(t, err)
+		return findings
+	}
+
+	for up, expected := range map[string][]string{
+		"CREATE INDEX people_name_idx ON public.people (name);":                     {"create-index-not-concurrently"},
+		"CREATE INDEX CONCURRENTLY people_name_idx ON public.people (name);":        {"concurrently-in-transaction"},
+		"ALTER TABLE public.people ADD COLUMN uuid uuid DEFAULT gen_random_uuid();": {"add-column-volatile-default"},
+		"ALTER TABLE public.people ADD COLUMN id bigserial;":                        {"add-column-volatile-default"},
+		"ALTER TABLE public.people ADD COLUMN active boolean DEFAULT true;":         {},
+		"ALTER TABLE public.people ALTER COLUMN name TYPE varchar(10);":             {"alter-column-type"},
+		"ALTER TABLE public.pets ADD CONSTRAINT pets_fk FOREIGN KEY (person_id) REFERENCES public.people (id);": {
+			"add-constraint-not-valid",
+		},
+		"ALTER TABLE public.pets ADD CONSTRAINT pets_fk FOREIGN KEY (person_id) REFERENCES public.people (id) NOT VALID;": {},
+		"ALTER TABLE public.pets ADD CONSTRAINT pets_uniq UNIQUE USING INDEX pets_uniq_idx;":                              {},
+		"ALTER TABLE public.people DROP COLUMN name;":                                                                     {"drop-column"},
+		"ALTER TABLE public.people ALTER COLUMN name DROP NOT NULL, ALTER COLUMN name DROP DEFAULT;":                      {},
+		"DROP TABLE public.people;":                         {"drop-table"},
+		"ALTER TABLE public.people RENAME COLUMN a TO b;":   {"rename"},
+		"ALTER TABLE public.people RENAME TO persons;":      {"rename"},
+		"COMMENT ON TABLE public.people IS 'CREATE INDEX';": {},
+		"CREATE TABLE public.pets (id int); CREATE INDEX pets_idx ON public.pets (id); ALTER TABLE pets ADD CHECK (id > 0);": {},
+		"-- pgmngr-lint: disable drop-table\nDROP TABLE public.people;":                                                      {},
+		"DROP TABLE public.people; -- pgmngr-lint: disable drop-table":                                                       {},
+		"-- pgmngr-lint: disable drop-table\nSELECT 1;\nDROP TABLE public.people;":                                           {"drop-table"},
+		"-- pgmngr-lint: disable-file all\nSELECT 1;\nDROP TABLE public.people;":                                             {},
+		"ALTER TABLE public.people DROP COLUMN a;\nDROP TABLE public.pets;\nCREATE INDEX i ON t (a);": {
+			"drop-column", "drop-table", "create-index-not-concurrently",
+		},
+	} {
+		require.Equal(t, expected, lintRuleIDs(lint(up)), up)
+	}
+
+	// no-transaction files can create indexes concurrently
+	findings := lint("-- pgmngr: no-transaction\nCREATE INDEX CONCURRENTLY people_name_idx ON public.people (name);")
+	require.Empty(t, findings)
+
+	findings = lint("DROP TABLE public.people;")
+	require.Equal(t, LintFinding{
+		Rule:     "drop-table",
+		Severity: LintWarning,
+		File:     filepath.Join(tempDir, "1600000001_lint.up.sql"),
+		Line:     1,
+		Message:  "table public.people dropped, make sure the code no longer uses it",
+	}, findings[0])
+	require.Empty(t, findings.Errors())
+
+	cfg.Migration.Lint.Rules = map[string]LintSeverity{"drop-table": LintError, "rename": LintOff}
+	findings = lint("DROP TABLE public.people;\nALTER TABLE public.people RENAME TO persons;")
+	require.Equal(t, []string{"drop-table"}, lintRuleIDs(findings))
+	require.Len(t, findings.Errors(), 1)
+
+	cfg.Migration.Lint.Rules = map[string]LintSeverity{"unknown": LintError}
+	_, err = LintFiles(cfg, []string{filepath.Join(tempDir, "1600000001_lint.up.sql")})
+	require.Error(t, err)
+	cfg.Migration.Lint.Rules = nil
+
+	writeTestMigration(t, tempDir, 1600000001, "lint", "-- pgmngr-lint: disable unknown\nSELECT 1;", "SELECT 1;")
+	_, err = LintFiles(cfg, []string{filepath.Join(tempDir, "1600000001_lint.up.sql")})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "line 1: unknown lint rule: unknown")
+
+	upFile := filepath.Join(tempDir, "1600000002_no_down.up.sql")
+	err = ioutil.WriteFile(upFile, []byte("SELECT 1;"), 0644)
+	require.NoError(t, err)
+	findings, err = LintFiles(cfg, []string{upFile})
+	require.NoError(t, err)
+	require.Equal(t, []string{"missing-down"}, lintRuleIDs(findings))
+}
+
+func TestMigrator_Lint(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "lint")
+
+	writeTestMigration(t, tempDir, 1600000001, "people", "CREATE TABLE public.people (id INT);", "DROP TABLE public.people;")
+
+	_, err := m.Up(ctx)
+	require.NoError(t, err)
+
+	// applied migrations are not linted
+	writeTestMigration(t, tempDir, 1600000002, "index", "CREATE INDEX people_id_idx ON public.people (id);", "SELECT 1;")
+	findings, err := m.Lint(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"create-index-not-concurrently"}, lintRuleIDs(findings))
+	require.Equal(t, filepath.Join(tempDir, "1600000002_index.up.sql"), findings[0].File)
+}
+
+func TestLintFindings_SARIF(t *testing.T) {
+	findings := LintFindings{
+		{Rule: "drop-table", Severity: LintWarning, File: "migrations/1_a.up.sql", Line: 3, Message: "table dropped"},
+		{Rule: "missing-down", Severity: LintWarning, File: "migrations/1_a.up.sql", Message: "no down"},
+	}
+	b, err := findings.SARIF()
+	require.NoError(t, err)
+
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string `json:"name"`
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+						Region *struct {
+							StartLine int `json:"startLine"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	err = json.Unmarshal(b, &log)
+	require.NoError(t, err)
+	require.Equal(t, "2.1.0", log.Version)
+	require.Len(t, log.Runs, 1)
+	require.Equal(t, "pgmngr", log.Runs[0].Tool.Driver.Name)
+	require.Len(t, log.Runs[0].Tool.Driver.Rules, len(lintRules))
+	require.Len(t, log.Runs[0].Results, 2)
+	require.Equal(t, "drop-table", log.Runs[0].Results[0].RuleID)
+	require.Equal(t, "warning", log.Runs[0].Results[0].Level)
+	require.Equal(t, "migrations/1_a.up.sql", log.Runs[0].Results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	require.Equal(t, 3, log.Runs[0].Results[0].Locations[0].PhysicalLocation.Region.StartLine)
+	require.Nil(t, log.Runs[0].Results[1].Locations[0].PhysicalLocation.Region)
+}
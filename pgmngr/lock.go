@@ -0,0 +1,93 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/ParaServices/errgo"
+)
+
+const lockPollInterval = 500 * time.Millisecond
+
+// migrationLockKey returns the advisory lock key of the schema migrations
+// table, so runners sharing a tracking table serialize on the same lock.
+func migrationLockKey(cfg *Config) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(cfg.Migration.Table.Schema + "." + cfg.Migration.Table.Name))
+	return int64(h.Sum64())
+}
+
+// lock takes the session level advisory lock of the schema migrations table
+// on conn, waiting up to the configured lock timeout. The returned function
+// releases the lock.
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) (func(), error) {
+	key := migrationLockKey(m.cfg)
+	release := func() {
+		// the lock has to be released even when ctx has been cancelled,
+		// otherwise it stays with the pooled connection
+		conn.ExecContext(context.Background(), stmntAdvisoryUnlock, key)
+	}
+
+	timeout := time.Duration(m.cfg.Migration.LockWaitTimeout) * time.Second
+	deadline := time.Now().Add(timeout)
+	waiting := false
+	for {
+		var locked bool
+		err := conn.QueryRowContext(ctx, stmntTryAdvisoryLock, key).Scan(&locked)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		if locked {
+			return release, nil
+		}
+
+		if !waiting {
+			m.notify(MigrationEvent{
+				Kind:    EventLockWaiting,
+				Message: m.cfg.Migration.Table.Schema + "." + m.cfg.Migration.Table.Name,
+			})
+			waiting = true
+		}
+
+		if time.Now().After(deadline) {
+			var pid sql.NullInt64
+			err = conn.QueryRowContext(ctx, stmntAdvisoryLockHolder, key).Scan(&pid)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, NewError(err)
+			}
+			return nil, lockTimeoutError(m.cfg, pid)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, NewError(ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+func lockTimeoutError(cfg *Config, pid sql.NullInt64) error {
+	holder := "unknown"
+	if pid.Valid {
+		holder = strconv.FormatInt(pid.Int64, 10)
+	}
+
+	errx := errgo.New(
+		fmt.Errorf(
+			"timed out after %v seconds waiting for the migration lock on: %s.%s held by backend pid: %s",
+			cfg.Migration.LockWaitTimeout,
+			cfg.Migration.Table.Schema,
+			cfg.Migration.Table.Name,
+			holder,
+		),
+	)
+	errx.Message = "failed to acquire the migration lock"
+	errx.Details.Add("table", cfg.Migration.Table.Schema+"."+cfg.Migration.Table.Name)
+	errx.Details.Add("lock_wait_timeout", strconv.Itoa(cfg.Migration.LockWaitTimeout))
+	errx.Details.Add("pid", holder)
+	return errx
+}
@@ -0,0 +1,54 @@
+package pgmngr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationLockKey(t *testing.T) {
+	cfgA := &Config{}
+	cfgA.Migration.Table.Schema = "public"
+	cfgA.Migration.Table.Name = "schema_migrations"
+
+	cfgB := &Config{}
+	cfgB.Migration.Table.Schema = "public"
+	cfgB.Migration.Table.Name = "schema_migrations"
+	require.Equal(t, migrationLockKey(cfgA), migrationLockKey(cfgB))
+
+	cfgB.Migration.Table.Schema = "other"
+	require.NotEqual(t, migrationLockKey(cfgA), migrationLockKey(cfgB))
+}
+
+func TestMigratorLock(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig(t)
+	cfg.Connection.Migration.Database = "postgres"
+	cfg.Migration.LockWaitTimeout = 1
+
+	m, err := NewMigrator(cfg)
+	require.NoError(t, err)
+	defer m.Close()
+
+	conn1, err := m.db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn1.Close()
+
+	conn2, err := m.db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn2.Close()
+
+	release, err := m.lock(ctx, conn1)
+	require.NoError(t, err)
+
+	_, err = m.lock(ctx, conn2)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "held by backend pid")
+
+	release()
+
+	release, err = m.lock(ctx, conn2)
+	require.NoError(t, err)
+	release()
+}
@@ -1,12 +1,10 @@
 package pgmngr
 
 import (
-	"bufio"
+	"context"
 	"database/sql"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -24,17 +22,48 @@ func generateMigrationVersion(c *Config) string {
 var upPlaceHolder = []byte(`-- SQL statement for migration goes here.`)
 var downPlaceHolder = []byte(`-- SQL statement for reversing/reverting the migration.`)
 
-type migrationType int
+// MigrationType is the direction migrations are executed in.
+type MigrationType int
 
 const (
 	// Forward execute up migrations
-	Forward migrationType = iota
+	Forward MigrationType = iota
 	// Rollback execute down migratiopns
 	Rollback
 )
 
+func (m MigrationType) String() string {
+	if m == Rollback {
+		return "rollback"
+	}
+	return "forward"
+}
+
+// MarshalText encodes the migration type as its name.
+func (m MigrationType) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText decodes the name of a migration type.
+func (m *MigrationType) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case Forward.String():
+		*m = Forward
+	case Rollback.String():
+		*m = Rollback
+	default:
+		return NewError(fmt.Errorf("unknown migration type: %s", b))
+	}
+	return nil
+}
+
 // CreateMigration generates new, empty migration files.
 func CreateMigration(c *Config, name string, noTransaction bool) error {
+	return writeMigrationFiles(c, name, noTransaction, upPlaceHolder, downPlaceHolder)
+}
+
+// writeMigrationFiles writes the up and down files of a new migration.
+func writeMigrationFiles(c *Config, name string, noTransaction bool, up, down []byte) error {
 	version := generateMigrationVersion(c)
 	prefix := fmt.Sprint(version, "_", name)
 
@@ -45,193 +74,182 @@ func CreateMigration(c *Config, name string, noTransaction bool) error {
 	upFilepath := filepath.Join(c.Migration.Directory, prefix+".up.sql")
 	downFilepath := filepath.Join(c.Migration.Directory, prefix+".down.sql")
 
-	err := ioutil.WriteFile(upFilepath, upPlaceHolder, 0644)
+	err := ioutil.WriteFile(upFilepath, up, 0644)
 	if err != nil {
 		return err
 	}
 	color.Info.Tips("Created migration file: %s", colorBlue(upFilepath))
 
-	err = ioutil.WriteFile(downFilepath, downPlaceHolder, 0644)
+	err = ioutil.WriteFile(downFilepath, down, 0644)
 	if err != nil {
 		return err
 	}
-	color.Info.Tips("Created migration file: %s", colorBlue(upFilepath))
+	color.Info.Tips("Created migration file: %s", colorBlue(downFilepath))
 
 	return nil
 }
 
 var colorBlue = color.FgBlue.Render
 
+// PrintEvent writes the progress of a Migrator to the terminal.
+func PrintEvent(e MigrationEvent) {
+	switch e.Kind {
+	case EventMigrationStarted:
+		color.Note.Tips("Running migration for: %s", colorBlue(e.File))
+	case EventMigrationApplied:
+		color.Success.Tips("Migration successful using migration file: %s", colorBlue(e.File))
+	case EventRollbackStarted:
+		color.Note.Tips("Running rollback for: %s", colorBlue(e.File))
+	case EventMigrationReverted:
+		color.Success.Tips("Rollback successful using migration file: %s", colorBlue(e.File))
+	case EventChecksumRepaired:
+		color.Success.Tips("Repaired checksum for migration file: %s", colorBlue(e.File))
+	case EventFileMissing:
+		color.Warn.Tips("Migration file missing for version: %v", colorBlue(e.Version))
+	case EventRetrying:
+		color.Warn.Tips("%s: %s", e.Message, colorBlue(e.File))
+	case EventMigrationBaselined:
+		color.Success.Tips("Marked as applied without running migration file: %s", colorBlue(e.File))
+	case EventMigrationImported:
+		color.Success.Tips("Imported applied migration: %s", colorBlue(e.File))
+	case EventFilesArchived:
+		color.Info.Tips("Archived the imported migration files in: %s", colorBlue(e.File))
+	case EventSquashRecorded:
+		color.Success.Tips("%s: %s", e.Message, colorBlue(e.File))
+	case EventRepeatableStarted:
+		color.Note.Tips("Running repeatable migration for: %s", colorBlue(e.File))
+	case EventOutOfOrder:
+		color.Warn.Tips("Migration is older than the newest applied migration: %s", colorBlue(e.File))
+	case EventMigrationSkipped:
+		color.Warn.Tips(
+			"Skipped migration limited to the %s environments, no environment selected: %s",
+			e.Message,
+			colorBlue(e.File),
+		)
+	case EventLockWaiting:
+		color.Note.Tips("Waiting for the migration lock on: %s", colorBlue(e.Message))
+	default:
+		color.Info.Tips("%s %s", e.Message, colorBlue(e.File))
+	}
+}
+
 func wrapInTransaction(file string) bool {
 	return !strings.Contains(file, ".no_txn.")
 }
 
 type execer interface {
-	Exec(query string, args ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
-// ApplyMigration ...
-func ApplyMigration(mType migrationType, cfg *Config) error {
-	// check if migration table exists
-	exists, err := schemaMigrationsTableExists(cfg)
+// ApplyMigration applies all unapplied migrations in ascending order when
+// mType is Forward. When mType is Rollback the latest applied migration is
+// reverted.
+func ApplyMigration(mType MigrationType, cfg *Config) error {
+	m, err := NewMigrator(cfg)
 	if err != nil {
 		return NewError(err)
 	}
-	if !exists {
-		err = createTableSchemaMigration(cfg)
-		if err != nil {
-			return NewError(err)
-		}
-	}
+	defer m.Close()
+	m.OnEvent = PrintEvent
 
-	mFiles, err := getUnAppliedMigrationFiles(mType, cfg)
-	if err != nil {
-		return NewError(err)
+	if mType == Rollback {
+		_, err = m.Down(context.Background(), 1)
+	} else {
+		_, err = m.Up(context.Background())
 	}
-	mFilesKeysSorted := make([]int64, len(mFiles))
-	i := 0
-	for k := range mFiles {
-		mFilesKeysSorted[i] = k
-		i++
-	}
-
-	sort.Slice(
-		mFilesKeysSorted,
-		func(i, j int) bool {
-			return mFilesKeysSorted[i] < mFilesKeysSorted[j]
-		},
-	)
-
-	dbURL, err := cfg.dbURL()
 	if err != nil {
 		return NewError(err)
 	}
 
-	db, err := sql.Open(pgDriver, dbURL)
-	if err != nil {
-		return NewError(err)
-	}
-	defer db.Close()
+	return nil
+}
 
-	err = pingDatabase(db, *cfg)
+// RollbackMigration reverts applied migrations in descending order by
+// running their down files. When version is non-zero every applied migration
+// newer than version is reverted, otherwise the latest steps migrations are.
+func RollbackMigration(cfg *Config, steps int, version int64) error {
+	m, err := NewMigrator(cfg)
 	if err != nil {
 		return NewError(err)
 	}
+	defer m.Close()
+	m.OnEvent = PrintEvent
 
-	rollback := func(tx *sql.Tx) {
-		if tx != nil {
-			tx.Rollback()
-		}
+	if version != 0 {
+		_, err = m.DownTo(context.Background(), version)
+	} else {
+		_, err = m.Down(context.Background(), steps)
 	}
-
-	_, err = db.Exec(stmntInsertSchemaMigrationFn)
 	if err != nil {
 		return NewError(err)
 	}
 
-	var exec execer
-	exec = db
-	for i := range mFilesKeysSorted {
-		var tx *sql.Tx
-		filePath := mFiles[mFilesKeysSorted[i]]
-		wrapInTxn := wrapInTransaction(filePath)
-		if wrapInTxn {
-			tx, err = db.Begin()
-			if err != nil {
-				return NewError(err)
-			}
-			exec = tx
-		}
-		color.Note.Tips("Running migration for: %s", colorBlue(filePath))
-		f, err := os.Open(filePath)
-		if err != nil {
-			return NewError(err)
-		}
-		defer f.Close()
+	return nil
+}
 
-		reader := bufio.NewReader(f)
+// rollbackVersions returns the applied versions that have to be reverted, in
+// the order they have to be reverted.
+func rollbackVersions(applied []int64, steps int, version int64) ([]int64, error) {
+	versions := make([]int64, len(applied))
+	copy(versions, applied)
+	sort.Slice(
+		versions,
+		func(i, j int) bool {
+			return versions[i] > versions[j]
+		},
+	)
 
-		var line string
-		var builder strings.Builder
-		defer builder.Reset()
-		for {
-			line, err = reader.ReadString('\n')
-			builder.WriteString(line)
-			if err != nil {
+	if version != 0 {
+		found := false
+		for i := range versions {
+			if versions[i] == version {
+				found = true
 				break
 			}
-			builder.WriteRune('\n')
-		}
-		if err != io.EOF {
-			return NewError(err)
-		}
-
-		_, err = exec.Exec(builder.String())
-		if err != nil {
-			rollback(tx)
-			return NewError(err)
 		}
-
-		schemaMigrationVersion, err := getVersionFromFileName(filepath.Base(filePath))
-		if err != nil {
-			rollback(tx)
-			return NewError(err)
+		if !found {
+			return nil, NewError(fmt.Errorf("version: %v has not been applied", version))
 		}
-
-		_, err = exec.Exec(
-			stmntInsertSchemaMigration,
-			cfg.Migration.Table.Schema,
-			cfg.Migration.Table.Name,
-			schemaMigrationVersion,
-		)
-
-		if err != nil {
-			rollback(tx)
-			return NewError(err)
+		n := 0
+		for n < len(versions) && versions[n] > version {
+			n++
 		}
-
-		if wrapInTxn {
-			err = tx.Commit()
-			if err != nil {
-				rollback(tx)
-				return NewError(err)
-			}
-		}
-		color.Success.Tips("Migration successful using migration file: %s", colorBlue(filePath))
-		continue
+		return versions[:n], nil
 	}
 
-	return nil
-}
-
-func schemaMigrationsTableExists(cfg *Config) (bool, error) {
-	dbURL, err := cfg.dbURL()
-	if err != nil {
-		return false, NewError(err)
+	if steps < 1 {
+		return nil, NewError(fmt.Errorf("invalid number of steps: %v", steps))
 	}
-
-	db, err := sql.Open(pgDriver, dbURL)
-	if err != nil {
-		return false, NewError(err)
+	if steps > len(versions) {
+		steps = len(versions)
 	}
-	defer db.Close()
+	return versions[:steps], nil
+}
 
-	err = pingDatabase(db, *cfg)
-	if err != nil {
-		return false, NewError(err)
-	}
+// schemaMigrationsTableMissingError is returned when reverting or repairing
+// migrations of a database whose schema migrations table does not exist.
+func schemaMigrationsTableMissingError(cfg *Config) error {
+	return NewError(
+		fmt.Errorf(
+			"table: %s.%s does not exist, no migrations have been applied",
+			cfg.Migration.Table.Schema,
+			cfg.Migration.Table.Name,
+		),
+	)
+}
 
-	row := db.QueryRow(
+func schemaMigrationsTableExists(ctx context.Context, q execer, cfg *Config) (bool, error) {
+	row := q.QueryRowContext(
+		ctx,
 		stmntSchemaMigrationTableExists,
 		cfg.Migration.Table.Schema,
 		cfg.Migration.Table.Name,
 	)
-	if err != nil {
-		return false, NewError(err)
-	}
 
 	var exists bool
-	err = row.Scan(&exists)
+	err := row.Scan(&exists)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
@@ -242,41 +260,18 @@ func schemaMigrationsTableExists(cfg *Config) (bool, error) {
 	return exists, nil
 }
 
-func createTableSchemaMigration(cfg *Config) error {
-	exists, err := schemaMigrationsTableExists(cfg)
+// ensureTableSchemaMigration creates the schema migrations table if it does
+// not exist and adds the columns introduced after it was first created.
+func ensureTableSchemaMigration(ctx context.Context, q execer, cfg *Config) error {
+	exists, err := schemaMigrationsTableExists(ctx, q, cfg)
 	if err != nil {
 		return NewError(err)
 	}
 
 	if !exists {
-		dbURL, err := cfg.dbURL()
-		if err != nil {
-			return NewError(err)
-		}
-
-		db, err := sql.Open(pgDriver, dbURL)
-		if err != nil {
-			return NewError(err)
-		}
-		defer db.Close()
-
-		err = pingDatabase(db, *cfg)
-		if err != nil {
-			return NewError(err)
-		}
-
-		_, err = db.Exec(stmntCreateSchemaMigrationsTableFn)
-		if err != nil {
-			return NewError(err)
-		}
-
-		stmnt, err := db.Prepare(stmntCreateSchemaMigrationsTable)
-		if err != nil {
-			return NewError(err)
-		}
-		defer stmnt.Close()
-
-		_, err = stmnt.Exec(
+		_, err = q.ExecContext(
+			ctx,
+			stmntCreateSchemaMigrationsTable,
 			cfg.Migration.Table.Schema,
 			cfg.Migration.Table.Name,
 		)
@@ -285,6 +280,21 @@ func createTableSchemaMigration(cfg *Config) error {
 		}
 	}
 
+	_, err = q.ExecContext(
+		ctx,
+		stmntUpgradeSchemaMigrationsTable,
+		cfg.Migration.Table.Schema,
+		cfg.Migration.Table.Name,
+	)
+	if err != nil {
+		return NewError(err)
+	}
+
+	err = ensureTableRepeatableMigrations(ctx, q, cfg)
+	if err != nil {
+		return NewError(err)
+	}
+
 	return nil
 }
 
@@ -321,119 +331,49 @@ func (m migrationFiles) Versions() []int64 {
 var isUpMigrationRegex = regexp.MustCompile(`^(.*\.up\.sql)$`)
 var isDownMigrationRegex = regexp.MustCompile(`^(.*\.down\.sql)$`)
 
-func getMigrationFiles(mType migrationType, cfg *Config) (migrationFiles, error) {
+func getMigrationFiles(mType MigrationType, src MigrationSource) (migrationFiles, error) {
+	files, err := src.Files()
+	if err != nil {
+		return nil, NewError(err)
+	}
+
 	mFiles := make(migrationFiles)
-	err := filepath.Walk(cfg.Migration.Directory, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
+	for _, path := range files {
+		if filepath.Ext(path) != ".sql" || isRepeatableMigrationFile(path) {
+			continue
+		}
+		versionStr, err := getVersionFromFileName(filepath.Base(path))
+		if err != nil {
+			return nil, NewError(err)
 		}
-		if filepath.Ext(path) == ".sql" {
-			versionStr, err := getVersionFromFileName(filepath.Base(path))
+		if mType == Forward && isUpMigrationRegex.Match([]byte(path)) {
+			versionInt64, err := strconv.ParseInt(versionStr, 10, 64)
 			if err != nil {
-				return NewError(err)
-			}
-			if mType == Forward && isUpMigrationRegex.Match([]byte(path)) {
-				versionInt64, err := strconv.ParseInt(versionStr, 10, 64)
-				if err != nil {
-					return NewError(err)
-				}
-				mFiles[versionInt64] = path
+				return nil, NewError(err)
 			}
-			if mType == Rollback && isDownMigrationRegex.Match([]byte(path)) {
-				versionInt64, err := strconv.ParseInt(versionStr, 10, 64)
-				if err != nil {
-					return NewError(err)
-				}
-				mFiles[versionInt64] = path
+			mFiles[versionInt64] = path
+		}
+		if mType == Rollback && isDownMigrationRegex.Match([]byte(path)) {
+			versionInt64, err := strconv.ParseInt(versionStr, 10, 64)
+			if err != nil {
+				return nil, NewError(err)
 			}
+			mFiles[versionInt64] = path
 		}
-		return nil
-	})
-	if err != nil {
-		return nil, NewError(err)
 	}
 
 	return mFiles, nil
 }
 
-func getUnAppliedMigrationFiles(mType migrationType, cfg *Config) (migrationFiles, error) {
-	dbURL, err := cfg.dbURL()
-	if err != nil {
-		return nil, NewError(err)
-	}
-
-	db, err := sql.Open(pgDriver, dbURL)
-	if err != nil {
-		return nil, NewError(err)
-	}
-	defer db.Close()
-
-	err = pingDatabase(db, *cfg)
-	if err != nil {
-		return nil, NewError(err)
-	}
-
-	mFiles, err := getMigrationFiles(mType, cfg)
-	if err != nil {
-		return nil, NewError(err)
-	}
-
-	appliedMigrations, err := getAllAppliedMigrations(cfg)
-	if err != nil {
-		return nil, NewError(err)
-	}
-	migrations, applied := sliceExclusionInt64s(mFiles.Versions(), appliedMigrations)
-
-	for i := range applied {
-		color.Note.Tips(
-			"Migration already applied: %v",
-			colorBlue(applied[i]),
-		)
-	}
-
-	unAppliedMigrations := make(migrationFiles)
-	for i := range migrations {
-		unAppliedMigrations[migrations[i]] = mFiles[migrations[i]]
-	}
-
-	return unAppliedMigrations, nil
-}
-
-func getAllAppliedMigrations(cfg *Config) ([]int64, error) {
-	dbURL, err := cfg.dbURL()
-	if err != nil {
-		return nil, NewError(err)
-	}
-
-	db, err := sql.Open(pgDriver, dbURL)
-	if err != nil {
-		return nil, NewError(err)
-	}
-	defer db.Close()
-
-	_, err = db.Exec(stmntAllSchemaMigrationsFn)
-	if err != nil {
-		return nil, NewError(err)
-	}
-
-	rows, err := db.Query(
-		stmntAllSchemaMigrations,
-		cfg.Migration.Table.Schema,
-		cfg.Migration.Table.Name,
+// pendingMigrations returns the versions of the migration files that have not
+// been applied, in ascending order.
+func pendingMigrations(mFiles migrationFiles, applied []MigrationRecord) []int64 {
+	pending, _ := sliceExclusionInt64s(mFiles.Versions(), appliedVersions(applied))
+	sort.Slice(
+		pending,
+		func(i, j int) bool {
+			return pending[i] < pending[j]
+		},
 	)
-	if err != nil {
-		return nil, NewError(err)
-	}
-
-	appliedMigrations := make([]int64, 0)
-	for rows.Next() {
-		var migration int64
-		err = rows.Scan(&migration)
-		if err != nil {
-			return nil, NewError(err)
-		}
-		appliedMigrations = append(appliedMigrations, migration)
-	}
-
-	return appliedMigrations, nil
+	return pending
 }
@@ -157,8 +157,7 @@ func TestApplyMigration(t *testing.T) {
 		require.True(t, exists)
 	}
 
-	migrations, err := getAllAppliedMigrations(cfg)
-	require.NoError(t, err)
+	migrations := testAppliedVersions(t, cfg)
 	require.Equal(t, count, len(migrations))
 }
 
@@ -211,3 +210,104 @@ func tableExists(schemaName, tableName string, cfg *Config) (bool, error) {
 
 	return exists, nil
 }
+
+func TestRollbackVersions(t *testing.T) {
+	applied := []int64{1500000001, 1500000003, 1500000002}
+
+	t.Run("steps", func(t *testing.T) {
+		versions, err := rollbackVersions(applied, 2, 0)
+		require.NoError(t, err)
+		require.Equal(t, []int64{1500000003, 1500000002}, versions)
+	})
+
+	t.Run("more steps than applied", func(t *testing.T) {
+		versions, err := rollbackVersions(applied, 10, 0)
+		require.NoError(t, err)
+		require.Equal(t, []int64{1500000003, 1500000002, 1500000001}, versions)
+	})
+
+	t.Run("invalid steps", func(t *testing.T) {
+		_, err := rollbackVersions(applied, 0, 0)
+		require.Error(t, err)
+	})
+
+	t.Run("to version", func(t *testing.T) {
+		versions, err := rollbackVersions(applied, 1, 1500000001)
+		require.NoError(t, err)
+		require.Equal(t, []int64{1500000003, 1500000002}, versions)
+	})
+
+	t.Run("to latest version", func(t *testing.T) {
+		versions, err := rollbackVersions(applied, 1, 1500000003)
+		require.NoError(t, err)
+		require.Empty(t, versions)
+	})
+
+	t.Run("to unapplied version", func(t *testing.T) {
+		_, err := rollbackVersions(applied, 1, 1500000004)
+		require.Error(t, err)
+	})
+}
+
+func writeTestMigration(t *testing.T, dir string, version int64, name, up, down string) {
+	prefix := filepath.Join(dir, fmt.Sprint(version, "_", name))
+	err := ioutil.WriteFile(prefix+".up.sql", []byte(up), 0644)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(prefix+".down.sql", []byte(down), 0644)
+	require.NoError(t, err)
+}
+
+func TestRollbackMigration(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "migrations_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	cfg := testConfig(t)
+	cfg.Connection.Migration.Database = "pgmngr_test_" + strings.ToLower(fake.Word()) + "_rollback"
+	cfg.Migration.Directory = tempDir
+	err = CreateDatabase(*cfg)
+	require.NoError(t, err)
+	defer func(t *testing.T) {
+		err = DropDatabase(*cfg)
+		require.NoError(t, err)
+	}(t)
+
+	tables := []string{"rollback_a", "rollback_b", "rollback_c"}
+	for i := range tables {
+		writeTestMigration(
+			t,
+			tempDir,
+			int64(1500000000+i),
+			tables[i],
+			"CREATE TABLE public."+tables[i]+"();",
+			"DROP TABLE public."+tables[i]+";",
+		)
+	}
+
+	err = ApplyMigration(Forward, cfg)
+	require.NoError(t, err)
+
+	err = RollbackMigration(cfg, 1, 0)
+	require.NoError(t, err)
+
+	exists, err := tableExists("public", "rollback_c", cfg)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	migrations := testAppliedVersions(t, cfg)
+	require.Equal(t, 2, len(migrations))
+
+	err = RollbackMigration(cfg, 0, 1500000000)
+	require.NoError(t, err)
+
+	exists, err = tableExists("public", "rollback_b", cfg)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	exists, err = tableExists("public", "rollback_a", cfg)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	migrations = testAppliedVersions(t, cfg)
+	require.Equal(t, []int64{1500000000}, migrations)
+}
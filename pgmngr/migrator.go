@@ -0,0 +1,759 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ParaServices/pgmngr/version"
+)
+
+// Migrator applies and reverts the migrations of the database described by
+// its Config. All methods honour the cancellation of the given context.
+type Migrator struct {
+	cfg    *Config
+	db     *sql.DB
+	ownsDB bool
+
+	// OnEvent, when set, is called as the migrator makes progress.
+	OnEvent func(MigrationEvent)
+	// Source, when set, provides the migration files instead of the
+	// migration directory of the Config.
+	Source MigrationSource
+	// Environment the migrations run in, the one of the Config by default.
+	// Migrations limited to other environments by their directives are left
+	// out.
+	Environment string
+}
+
+// NewMigrator opens a connection to the migration database of cfg. Unset
+// config fields are replaced with their defaults.
+func NewMigrator(cfg *Config) (*Migrator, error) {
+	cfg.setDefaults()
+
+	dbURL, err := cfg.dbURL()
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	db, err := sql.Open(pgDriver, dbURL)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	err = pingDatabase(db, *cfg)
+	if err != nil {
+		db.Close()
+		return nil, NewError(err)
+	}
+
+	return &Migrator{cfg: cfg, db: db, ownsDB: true, Environment: cfg.Environment}, nil
+}
+
+// NewMigratorWithDB returns a Migrator using an existing database handle. The
+// handle is not closed by Close. Unset config fields are replaced with their
+// defaults, connection settings are ignored.
+func NewMigratorWithDB(db *sql.DB, cfg *Config) *Migrator {
+	cfg.setDefaults()
+	return &Migrator{cfg: cfg, db: db, Environment: cfg.Environment}
+}
+
+// Close closes the database handle opened by NewMigrator.
+func (m *Migrator) Close() error {
+	if m.ownsDB {
+		return m.db.Close()
+	}
+	return nil
+}
+
+// EventKind identifies a MigrationEvent.
+type EventKind string
+
+const (
+	// EventMigrationStarted a migration file is about to be executed
+	EventMigrationStarted EventKind = "migration_started"
+	// EventMigrationApplied a migration file has been applied
+	EventMigrationApplied EventKind = "migration_applied"
+	// EventRollbackStarted a down migration file is about to be executed
+	EventRollbackStarted EventKind = "rollback_started"
+	// EventMigrationReverted a migration has been reverted
+	EventMigrationReverted EventKind = "migration_reverted"
+	// EventChecksumRepaired the checksum of an applied migration was re-stamped
+	EventChecksumRepaired EventKind = "checksum_repaired"
+	// EventFileMissing an applied migration has no file
+	EventFileMissing EventKind = "file_missing"
+	// EventLockWaiting the migration lock is held by another runner
+	EventLockWaiting EventKind = "lock_waiting"
+	// EventMigrationBaselined a migration is marked as applied without
+	// running it
+	EventMigrationBaselined EventKind = "migration_baselined"
+	// EventMigrationImported a migration applied by another tool is marked
+	// as applied
+	EventMigrationImported EventKind = "migration_imported"
+	// EventSquashRecorded the migrations replaced by a squashed baseline are
+	// recorded as covered by it
+	EventSquashRecorded EventKind = "squash_recorded"
+	// EventFilesArchived the files of another tool are moved to the archive
+	// directory given as the file
+	EventFilesArchived EventKind = "files_archived"
+	// EventRepeatableStarted a repeatable migration is about to run
+	EventRepeatableStarted EventKind = "repeatable_started"
+	// EventRetrying a migration that failed to acquire a lock is retried
+	EventRetrying EventKind = "retrying"
+	// EventMigrationSkipped a pending migration limited to environments,
+	// given as the message, is skipped as no environment is selected
+	EventMigrationSkipped EventKind = "migration_skipped"
+	// EventOutOfOrder a pending migration is older than the newest applied
+	// migration
+	EventOutOfOrder EventKind = "out_of_order"
+)
+
+// MigrationEvent describes the progress of a Migrator.
+type MigrationEvent struct {
+	Kind    EventKind
+	Version int64
+	File    string
+	Message string
+}
+
+func (m *Migrator) source() MigrationSource {
+	if m.Source != nil {
+		return m.Source
+	}
+	return NewDirectorySource(m.cfg.Migration.Directory)
+}
+
+func (m *Migrator) notify(e MigrationEvent) {
+	if m.OnEvent != nil {
+		m.OnEvent(e)
+	}
+}
+
+// MigrationResult is the outcome of executing a single migration file.
+type MigrationResult struct {
+	PlannedMigration
+	Type     MigrationType `json:"type"`
+	Duration time.Duration `json:"duration"`
+}
+
+// sessionFunctions are the temporary functions used to manage the schema
+// migrations table, created once per session.
+var sessionFunctions = []string{
+	stmntCreateSchemaMigrationsTableFn,
+	stmntUpgradeSchemaMigrationsTableFn,
+	stmntAppliedSchemaMigrationsFn,
+	stmntInsertSchemaMigrationFn,
+	stmntDeleteSchemaMigrationFn,
+	stmntDeleteSchemaMigrationsBeforeFn,
+	stmntUpdateSchemaMigrationChecksumFn,
+	stmntCreateRepeatableMigrationsTableFn,
+	stmntUpsertRepeatableMigrationFn,
+	stmntAppliedRepeatableMigrationsFn,
+}
+
+// session runs fn on a dedicated connection so the temporary functions and
+// the advisory lock, when requested, belong to the same session.
+func (m *Migrator) session(ctx context.Context, lock bool, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return NewError(err)
+	}
+	defer conn.Close()
+
+	if lock {
+		release, err := m.lock(ctx, conn)
+		if err != nil {
+			return NewError(err)
+		}
+		defer release()
+	}
+
+	for i := range sessionFunctions {
+		_, err = conn.ExecContext(ctx, sessionFunctions[i])
+		if err != nil {
+			return NewError(err)
+		}
+	}
+
+	return fn(conn)
+}
+
+// Plan returns the pending migrations in the order Up would apply them. The
+// database is only read.
+func (m *Migrator) Plan(ctx context.Context) (*Plan, error) {
+	var plan *Plan
+	err := m.session(ctx, false, func(conn *sql.Conn) error {
+		exists, err := schemaMigrationsTableExists(ctx, conn, m.cfg)
+		if err != nil {
+			return NewError(err)
+		}
+
+		applied := make([]MigrationRecord, 0)
+		if exists {
+			applied, err = getAppliedMigrations(ctx, conn, m.cfg)
+			if err != nil {
+				return NewError(err)
+			}
+		}
+
+		mFiles, err := m.migrationFiles(Forward)
+		if err != nil {
+			return NewError(err)
+		}
+
+		plan, err = forwardPlan(m.source(), mFiles, applied, m.Environment)
+		if err != nil {
+			return NewError(err)
+		}
+		m.warnSkipped(plan, nil)
+
+		plan.Repeatable, err = m.repeatablePlan(ctx, conn)
+		return err
+	})
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	return plan, nil
+}
+
+// Up applies all pending migrations in ascending order. It refuses to run when
+// the file of an applied migration has changed since it was applied.
+func (m *Migrator) Up(ctx context.Context) ([]MigrationResult, error) {
+	return m.migrate(ctx, func(conn *sql.Conn) ([]MigrationResult, error) {
+		return m.forward(ctx, conn, nil, nil)
+	})
+}
+
+// ApplyPlan applies the pending migrations like Up, but only when they are
+// exactly the migrations of a plan previously returned by Plan.
+func (m *Migrator) ApplyPlan(ctx context.Context, expected *Plan) ([]MigrationResult, error) {
+	if expected == nil {
+		return nil, NewError(fmt.Errorf("plan not given"))
+	}
+	return m.migrate(ctx, func(conn *sql.Conn) ([]MigrationResult, error) {
+		return m.forward(ctx, conn, expected, nil)
+	})
+}
+
+// Down reverts the latest n applied migrations in descending order.
+func (m *Migrator) Down(ctx context.Context, n int) ([]MigrationResult, error) {
+	return m.rollback(ctx, func(conn *sql.Conn) ([]MigrationResult, error) {
+		applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		versions, err := rollbackVersions(appliedVersions(applied), n, 0)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		return m.revert(ctx, conn, versions)
+	})
+}
+
+// DownTo reverts, in descending order, every applied migration newer than
+// version.
+func (m *Migrator) DownTo(ctx context.Context, version int64) ([]MigrationResult, error) {
+	return m.rollback(ctx, func(conn *sql.Conn) ([]MigrationResult, error) {
+		applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		versions, err := rollbackVersions(appliedVersions(applied), 0, version)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		return m.revert(ctx, conn, versions)
+	})
+}
+
+// Redo reverts the latest applied migration and applies it again.
+func (m *Migrator) Redo(ctx context.Context) ([]MigrationResult, error) {
+	return m.rollback(ctx, func(conn *sql.Conn) ([]MigrationResult, error) {
+		applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		if len(applied) == 0 {
+			return nil, NewError(fmt.Errorf("no migrations have been applied"))
+		}
+
+		versions, err := rollbackVersions(appliedVersions(applied), 1, 0)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		// the migration is only reverted when it can be applied again
+		mFiles, err := m.migrationFiles(Forward)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		remaining := make([]MigrationRecord, 0, len(applied)-1)
+		for i := range applied {
+			if applied[i].Version != versions[0] {
+				remaining = append(remaining, applied[i])
+			}
+		}
+		plan, err := m.pendingPlan(ctx, conn, mFiles, remaining, nil, func(v int64) bool {
+			return v == versions[0]
+		})
+		if err != nil {
+			return nil, NewError(err)
+		}
+		if len(plan.Migrations) == 0 {
+			return nil, NewError(
+				fmt.Errorf("up migration file for version: %v not found", versions[0]),
+			)
+		}
+
+		results, err := m.revert(ctx, conn, versions)
+		if err != nil {
+			return results, NewError(err)
+		}
+
+		reapplied, err := m.forward(ctx, conn, nil, func(v int64) bool {
+			return v == versions[0]
+		})
+		results = append(results, reapplied...)
+		if err != nil {
+			return results, NewError(err)
+		}
+
+		return results, nil
+	})
+}
+
+// To migrates the database to exactly version: applied migrations newer than
+// version are reverted, in descending order, and pending migrations up to and
+// including version are applied, in ascending order. A version of 0 reverts
+// every applied migration.
+func (m *Migrator) To(ctx context.Context, version int64) ([]MigrationResult, error) {
+	return m.migrate(ctx, func(conn *sql.Conn) ([]MigrationResult, error) {
+		applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		mFiles, err := m.migrationFiles(Forward)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		versions := make([]int64, 0)
+		found := version == 0
+		for _, v := range appliedVersions(applied) {
+			found = found || v == version
+			if v > version {
+				versions = append(versions, v)
+			}
+		}
+		if _, ok := mFiles[version]; ok {
+			found = true
+		}
+		if !found {
+			return nil, NewError(fmt.Errorf("version: %v not found", version))
+		}
+
+		if len(versions) > 0 {
+			versions, err = rollbackVersions(versions, len(versions), 0)
+			if err != nil {
+				return nil, NewError(err)
+			}
+		}
+
+		results, err := m.revert(ctx, conn, versions)
+		if err != nil {
+			return results, NewError(err)
+		}
+
+		applyResults, err := m.forward(ctx, conn, nil, func(v int64) bool {
+			return v <= version
+		})
+		results = append(results, applyResults...)
+		if err != nil {
+			return results, NewError(err)
+		}
+
+		return results, nil
+	})
+}
+
+// migrate runs fn in a locked session once the schema migrations table is
+// up to date.
+func (m *Migrator) migrate(ctx context.Context, fn func(conn *sql.Conn) ([]MigrationResult, error)) ([]MigrationResult, error) {
+	results := make([]MigrationResult, 0)
+	err := m.session(ctx, true, func(conn *sql.Conn) error {
+		err := ensureTableSchemaMigration(ctx, conn, m.cfg)
+		if err != nil {
+			return NewError(err)
+		}
+
+		fnResults, err := fn(conn)
+		results = append(results, fnResults...)
+		return err
+	})
+	if err != nil {
+		return results, NewError(err)
+	}
+
+	return results, nil
+}
+
+// rollback runs fn like migrate, but fails rather than creating the schema
+// migrations table when it does not exist, as there is nothing to revert.
+func (m *Migrator) rollback(ctx context.Context, fn func(conn *sql.Conn) ([]MigrationResult, error)) ([]MigrationResult, error) {
+	results := make([]MigrationResult, 0)
+	err := m.session(ctx, true, func(conn *sql.Conn) error {
+		exists, err := schemaMigrationsTableExists(ctx, conn, m.cfg)
+		if err != nil {
+			return NewError(err)
+		}
+		if !exists {
+			return schemaMigrationsTableMissingError(m.cfg)
+		}
+
+		err = ensureTableSchemaMigration(ctx, conn, m.cfg)
+		if err != nil {
+			return NewError(err)
+		}
+
+		fnResults, err := fn(conn)
+		results = append(results, fnResults...)
+		return err
+	})
+	if err != nil {
+		return results, NewError(err)
+	}
+
+	return results, nil
+}
+
+// forward applies the pending migrations accepted by include, all of them
+// when include is nil, in ascending order.
+func (m *Migrator) forward(ctx context.Context, conn *sql.Conn, expected *Plan, include func(version int64) bool) ([]MigrationResult, error) {
+	applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	mFiles, err := m.migrationFiles(Forward)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	applied, err = m.recordSquashes(ctx, conn, mFiles, applied)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	plan, err := m.pendingPlan(ctx, conn, mFiles, applied, expected, include)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	results := make([]MigrationResult, 0)
+	for i := range plan.Migrations {
+		result, err := m.runWithRetry(ctx, conn, Forward, plan.Migrations[i])
+		if err != nil {
+			return results, NewError(err)
+		}
+		results = append(results, result)
+	}
+
+	for i := range plan.Repeatable {
+		result, err := m.runWithRetry(ctx, conn, Forward, plan.Repeatable[i])
+		if err != nil {
+			return results, NewError(err)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// pendingPlan returns the plan of the pending migrations accepted by include,
+// all of them when include is nil, once the applied migrations, the plan
+// and the requirements of the migrations are validated.
+func (m *Migrator) pendingPlan(ctx context.Context, conn *sql.Conn, mFiles map[int64]string, applied []MigrationRecord, expected *Plan, include func(version int64) bool) (*Plan, error) {
+	mismatches, err := findChecksumMismatches(m.source(), mFiles, applied)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	if len(mismatches) > 0 {
+		return nil, checksumMismatchError(mismatches)
+	}
+
+	plan, err := forwardPlan(m.source(), mFiles, applied, m.Environment)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	m.warnSkipped(plan, include)
+
+	plan.Repeatable, err = m.repeatablePlan(ctx, conn)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	if expected != nil {
+		if differences := expected.diff(plan); len(differences) > 0 {
+			return nil, planMismatchError(differences)
+		}
+	}
+
+	if include != nil {
+		included := make([]PlannedMigration, 0)
+		for i := range plan.Migrations {
+			if include(plan.Migrations[i].Version) {
+				included = append(included, plan.Migrations[i])
+			}
+		}
+		plan.Migrations = included
+		// repeatable migrations only run once all migrations are applied
+		plan.Repeatable = nil
+	}
+
+	err = m.checkOutOfOrder(plan)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	err = plan.checkRequires(applied)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	return plan, nil
+}
+
+// revert runs the down files of the given versions in the given order.
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, versions []int64) ([]MigrationResult, error) {
+	mFiles, err := m.migrationFiles(Rollback)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	plan := make([]PlannedMigration, len(versions))
+	for i := range versions {
+		filePath, ok := mFiles[versions[i]]
+		if !ok {
+			return nil, NewError(
+				fmt.Errorf("down migration file for version: %v not found", versions[i]),
+			)
+		}
+		plan[i], err = newPlannedMigration(m.source(), versions[i], filePath)
+		if err != nil {
+			return nil, NewError(err)
+		}
+	}
+
+	results := make([]MigrationResult, 0)
+	for i := range plan {
+		result, err := m.runWithRetry(ctx, conn, Rollback, plan[i])
+		if err != nil {
+			return results, NewError(err)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func appliedVersions(applied []MigrationRecord) []int64 {
+	versions := make([]int64, len(applied))
+	for i := range applied {
+		versions[i] = applied[i].Version
+	}
+	return versions
+}
+
+// Status joins the migration files against the schema migrations table and
+// returns the state of every known version.
+func (m *Migrator) Status(ctx context.Context) (MigrationStatuses, error) {
+	var statuses MigrationStatuses
+	err := m.session(ctx, false, func(conn *sql.Conn) error {
+		mFiles, err := m.migrationFiles(Forward)
+		if err != nil {
+			return NewError(err)
+		}
+
+		exists, err := schemaMigrationsTableExists(ctx, conn, m.cfg)
+		if err != nil {
+			return NewError(err)
+		}
+
+		applied := make([]MigrationRecord, 0)
+		if exists {
+			applied, err = getAppliedMigrations(ctx, conn, m.cfg)
+			if err != nil {
+				return NewError(err)
+			}
+		}
+
+		statuses = migrationStatuses(mFiles, applied)
+		return nil
+	})
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	return statuses, nil
+}
+
+// run executes a single migration file and records it in the schema
+// migrations table, inserting the version for Forward and deleting it for
+// Rollback. Repeatable migrations are recorded in the repeatable migrations
+// table instead. The file is wrapped in a transaction unless it is a no_txn
+// migration.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, mType MigrationType, pm PlannedMigration) (MigrationResult, error) {
+	result := MigrationResult{PlannedMigration: pm, Type: mType}
+
+	rollback := func(tx *sql.Tx) {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}
+
+	if mType == Rollback {
+		m.notify(MigrationEvent{Kind: EventRollbackStarted, Version: pm.Version, File: pm.File})
+	} else if pm.Repeatable {
+		m.notify(MigrationEvent{Kind: EventRepeatableStarted, File: pm.File})
+	} else {
+		m.notify(MigrationEvent{Kind: EventMigrationStarted, Version: pm.Version, File: pm.File})
+	}
+
+	var stmnts []statement
+	sum := ""
+	if !isGoMigrationFile(pm.File) {
+		b, err := m.source().ReadFile(pm.File)
+		if err != nil {
+			return result, NewError(err)
+		}
+
+		stmnts, err = splitStatements(string(b))
+		if err != nil {
+			return result, NewError(fmt.Errorf("%s: %v", pm.File, err))
+		}
+		sum = checksum(b)
+	}
+
+	lockTimeout, statementTimeout, err := m.timeouts(pm)
+	if err != nil {
+		return result, NewError(err)
+	}
+
+	start := time.Now()
+
+	var exec execer
+	exec = conn
+	var tx *sql.Tx
+	if pm.Transaction {
+		tx, err = conn.BeginTx(ctx, nil)
+		if err != nil {
+			return result, NewError(err)
+		}
+		exec = tx
+	} else {
+		// outside of a transaction the timeouts are set for the session
+		defer resetTimeouts(conn, lockTimeout, statementTimeout)
+	}
+
+	err = setTimeouts(ctx, exec, pm.Transaction, lockTimeout, statementTimeout)
+	if err != nil {
+		rollback(tx)
+		return result, NewError(err)
+	}
+
+	if isGoMigrationFile(pm.File) {
+		err = m.runGoMigration(ctx, tx, mType, pm.Version)
+		if err != nil {
+			rollback(tx)
+			return result, goMigrationError(pm.File, err)
+		}
+	}
+
+	for i := range stmnts {
+		err = execStatement(ctx, conn, tx, stmnts[i])
+		if err != nil {
+			rollback(tx)
+			return result, statementError(pm.File, stmnts[i], err)
+		}
+	}
+	result.Duration = time.Since(start)
+
+	hostname, _ := os.Hostname()
+	switch {
+	case pm.Repeatable:
+		_, err = exec.ExecContext(
+			ctx,
+			stmntUpsertRepeatableMigration,
+			m.cfg.Migration.Table.Schema,
+			repeatableTable(m.cfg),
+			pm.Name,
+			sum,
+			filepath.Base(pm.File),
+			result.Duration.Milliseconds(),
+			hostname,
+			version.AppRevisionOrTag(),
+		)
+	case mType == Rollback:
+		_, err = exec.ExecContext(
+			ctx,
+			stmntDeleteSchemaMigration,
+			m.cfg.Migration.Table.Schema,
+			m.cfg.Migration.Table.Name,
+			pm.Version,
+		)
+	default:
+		err = m.recordMigration(ctx, exec, pm, sum, result.Duration)
+	}
+	if err != nil {
+		rollback(tx)
+		return result, NewError(err)
+	}
+
+	if tx != nil {
+		err = tx.Commit()
+		if err != nil {
+			rollback(tx)
+			return result, NewError(err)
+		}
+	}
+
+	if mType == Rollback {
+		m.notify(MigrationEvent{Kind: EventMigrationReverted, Version: pm.Version, File: pm.File})
+	} else {
+		m.notify(MigrationEvent{Kind: EventMigrationApplied, Version: pm.Version, File: pm.File})
+	}
+	return result, nil
+}
+
+// recordMigration inserts an applied migration into the schema migrations
+// table.
+func (m *Migrator) recordMigration(ctx context.Context, exec execer, pm PlannedMigration, sum string, duration time.Duration) error {
+	hostname, _ := os.Hostname()
+	_, err := exec.ExecContext(
+		ctx,
+		stmntInsertSchemaMigration,
+		m.cfg.Migration.Table.Schema,
+		m.cfg.Migration.Table.Name,
+		pm.Version,
+		sum,
+		filepath.Base(pm.File),
+		pm.Name,
+		duration.Milliseconds(),
+		hostname,
+		version.AppRevisionOrTag(),
+		pm.Transaction,
+	)
+	if err != nil {
+		return NewError(err)
+	}
+	return nil
+}
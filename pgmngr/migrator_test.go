@@ -0,0 +1,118 @@
+package pgmngr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "migrator")
+	cfg := m.cfg
+
+	writeTestMigration(t, tempDir, 1600000001, "a", "CREATE TABLE public.migrator_a();", "DROP TABLE public.migrator_a;")
+	writeTestMigration(t, tempDir, 1600000002, "b", "CREATE TABLE public.migrator_b();", "DROP TABLE public.migrator_b;")
+
+	plan, err := m.Plan(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(plan.Migrations))
+
+	results, err := m.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+	require.Equal(t, int64(1600000001), results[0].Version)
+
+	plan, err = m.Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, plan.Migrations)
+
+	record, err := m.Show(ctx, 1600000001)
+	require.NoError(t, err)
+	require.Equal(t, "a", record.Name)
+	require.Equal(t, "1600000001_a.up.sql", record.FileName)
+	require.Equal(t, checksum([]byte("CREATE TABLE public.migrator_a();")), record.Checksum)
+	require.Equal(t, cfg.Connection.Migration.Username, record.AppliedBy)
+	require.NotNil(t, record.Transaction)
+	require.True(t, *record.Transaction)
+
+	_, err = m.Show(ctx, 1600000009)
+	require.Error(t, err)
+
+	results, err = m.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(results))
+	require.Equal(t, int64(1600000002), results[0].Version)
+	require.Equal(t, Rollback, results[0].Type)
+
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(statuses.Pending()))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = m.Up(cancelled)
+	require.Error(t, err)
+}
+
+func TestMigratorRedoAndTo(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "redo")
+	cfg := m.cfg
+
+	for i, name := range []string{"a", "b", "c"} {
+		writeTestMigration(
+			t,
+			tempDir,
+			int64(1600000000+i),
+			name,
+			"CREATE TABLE public.redo_"+name+"();",
+			"DROP TABLE public.redo_"+name+";",
+		)
+	}
+
+	_, err := m.Down(ctx, 1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not exist, no migrations have been applied")
+	_, err = m.DownTo(ctx, 1600000000)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not exist, no migrations have been applied")
+
+	results, err := m.To(ctx, 1600000001)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+	require.Equal(t, []int64{1600000000, 1600000001}, testAppliedVersions(t, cfg))
+
+	results, err = m.Redo(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+	require.Equal(t, Rollback, results[0].Type)
+	require.Equal(t, int64(1600000001), results[0].Version)
+	require.Equal(t, Forward, results[1].Type)
+	require.Equal(t, int64(1600000001), results[1].Version)
+	require.Equal(t, []int64{1600000000, 1600000001}, testAppliedVersions(t, cfg))
+
+	// nothing is reverted when the migration cannot be applied again
+	writeTestMigration(t, tempDir, 1600000000, "a", "CREATE TABLE public.redo_a(id INT);", "DROP TABLE public.redo_a;")
+	_, err = m.Redo(ctx)
+	require.Error(t, err)
+	require.Equal(t, []int64{1600000000, 1600000001}, testAppliedVersions(t, cfg))
+	writeTestMigration(t, tempDir, 1600000000, "a", "CREATE TABLE public.redo_a();", "DROP TABLE public.redo_a;")
+
+	_, err = m.To(ctx, 1600000002)
+	require.NoError(t, err)
+	require.Equal(t, []int64{1600000000, 1600000001, 1600000002}, testAppliedVersions(t, cfg))
+
+	results, err = m.To(ctx, 1600000000)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+	require.Equal(t, []int64{1600000000}, testAppliedVersions(t, cfg))
+
+	_, err = m.To(ctx, 1600000009)
+	require.Error(t, err)
+
+	_, err = m.To(ctx, 0)
+	require.NoError(t, err)
+	require.Empty(t, testAppliedVersions(t, cfg))
+}
@@ -0,0 +1,358 @@
+package pgmngr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ParaServices/errgo"
+)
+
+// PlannedMigration is a migration file that is about to be executed.
+type PlannedMigration struct {
+	Version     int64  `json:"version"`
+	Name        string `json:"name"`
+	File        string `json:"file"`
+	Checksum    string `json:"checksum"`
+	Transaction bool   `json:"transaction"`
+	// OutOfOrder is set for pending migrations older than the newest applied
+	// migration.
+	OutOfOrder bool `json:"out_of_order,omitempty"`
+	// LockTimeout and StatementTimeout are the timeouts of the directives
+	// of the file, overriding the configured ones when set.
+	LockTimeout      time.Duration `json:"lock_timeout,omitempty"`
+	StatementTimeout time.Duration `json:"statement_timeout,omitempty"`
+	// Environments the migration is limited to, all when empty.
+	Environments []string `json:"environments,omitempty"`
+	// Requires lists the versions that must be applied first.
+	Requires []int64 `json:"requires,omitempty"`
+	// Repeatable is set for repeatable migrations, which have no version.
+	Repeatable bool `json:"repeatable,omitempty"`
+	// Squash is set for baselines replacing every migration up to their
+	// version.
+	Squash bool `json:"squash,omitempty"`
+}
+
+// Plan lists, in execution order, the migrations a run would execute.
+type Plan struct {
+	Type       MigrationType      `json:"type"`
+	Migrations []PlannedMigration `json:"migrations"`
+	// Repeatable lists the new or changed repeatable migrations, run after
+	// the versioned migrations.
+	Repeatable []PlannedMigration `json:"repeatable,omitempty"`
+	// Skipped lists the pending migrations limited to environments, which
+	// are left out when no environment is selected.
+	Skipped []PlannedMigration `json:"skipped,omitempty"`
+}
+
+// Empty reports whether the plan has nothing to run.
+func (p *Plan) Empty() bool {
+	return len(p.Migrations) == 0 && len(p.Repeatable) == 0
+}
+
+// LoadPlan reads a plan previously written by Save.
+func LoadPlan(filePath string) (*Plan, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	plan := &Plan{}
+	err = json.Unmarshal(b, plan)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	return plan, nil
+}
+
+// Save writes the plan as JSON so it can be verified by ApplyPlan later.
+func (p *Plan) Save(filePath string) error {
+	b, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return NewError(err)
+	}
+
+	err = ioutil.WriteFile(filePath, append(b, '\n'), 0644)
+	if err != nil {
+		return NewError(err)
+	}
+
+	return nil
+}
+
+// diff returns the differences between the migrations of the plan and the
+// actual plan, an empty result meaning both would execute the same files.
+func (p *Plan) diff(actual *Plan) []string {
+	differences := make([]string, 0)
+	if p.Type != actual.Type {
+		differences = append(
+			differences,
+			fmt.Sprintf("type: %s planned, %s actual", p.Type, actual.Type),
+		)
+	}
+
+	planned := make(map[int64]PlannedMigration)
+	for _, pm := range p.Migrations {
+		planned[pm.Version] = pm
+	}
+	for i, am := range actual.Migrations {
+		pm, ok := planned[am.Version]
+		if !ok {
+			differences = append(differences, fmt.Sprintf("version: %v is pending but not planned", am.Version))
+			continue
+		}
+		delete(planned, am.Version)
+		if pm.Checksum != am.Checksum {
+			differences = append(differences, fmt.Sprintf("version: %v file: %s has changed", am.Version, am.File))
+		}
+		if i >= len(p.Migrations) || p.Migrations[i].Version != am.Version {
+			differences = append(differences, fmt.Sprintf("version: %v is planned in a different order", am.Version))
+		}
+	}
+	for _, pm := range p.Migrations {
+		if _, ok := planned[pm.Version]; ok {
+			differences = append(differences, fmt.Sprintf("version: %v is planned but not pending", pm.Version))
+		}
+	}
+
+	repeatable := make(map[string]string)
+	for _, pm := range p.Repeatable {
+		repeatable[pm.File] = pm.Checksum
+	}
+	for _, am := range actual.Repeatable {
+		sum, ok := repeatable[am.File]
+		if !ok {
+			differences = append(differences, fmt.Sprintf("repeatable file: %s is pending but not planned", am.File))
+			continue
+		}
+		delete(repeatable, am.File)
+		if sum != am.Checksum {
+			differences = append(differences, fmt.Sprintf("repeatable file: %s has changed", am.File))
+		}
+	}
+	for _, pm := range p.Repeatable {
+		if _, ok := repeatable[pm.File]; ok {
+			differences = append(differences, fmt.Sprintf("repeatable file: %s is planned but not pending", pm.File))
+		}
+	}
+
+	return differences
+}
+
+func planMismatchError(differences []string) error {
+	errx := errgo.New(
+		fmt.Errorf("pending migrations do not match the plan:\n%s", strings.Join(differences, "\n")),
+	)
+	errx.Message = "pending migrations do not match the plan"
+	for i := range differences {
+		errx.Details.Add(strconv.Itoa(i), differences[i])
+	}
+	return errx
+}
+
+// newPlannedMigration reads the migration file, computing its checksum and
+// parsing its directives. The .no_txn file name infix is still honored. Go
+// migrations have neither a checksum nor directives.
+func newPlannedMigration(src MigrationSource, version int64, filePath string) (PlannedMigration, error) {
+	if isGoMigrationFile(filePath) {
+		g, ok := registeredMigration(version)
+		if !ok {
+			return PlannedMigration{}, NewError(
+				fmt.Errorf("Go migration for version: %v is not registered", version),
+			)
+		}
+		return PlannedMigration{
+			Version:     version,
+			Name:        g.name,
+			File:        filePath,
+			Transaction: !g.noTransaction(),
+		}, nil
+	}
+
+	b, err := src.ReadFile(filePath)
+	if err != nil {
+		return PlannedMigration{}, NewError(err)
+	}
+
+	d, err := parseDirectives(b)
+	if err != nil {
+		return PlannedMigration{}, NewError(fmt.Errorf("%s: %v", filePath, err))
+	}
+
+	name := getNameFromFileName(filepath.Base(filePath))
+	repeatable := isRepeatableMigrationFile(filePath)
+	if repeatable {
+		name = getNameFromRepeatableFileName(filepath.Base(filePath))
+	}
+
+	return PlannedMigration{
+		Version:          version,
+		Name:             name,
+		File:             filePath,
+		Checksum:         checksum(b),
+		Transaction:      wrapInTransaction(filePath) && !d.NoTransaction,
+		LockTimeout:      d.LockTimeout,
+		StatementTimeout: d.StatementTimeout,
+		Environments:     d.Environments,
+		Requires:         d.Requires,
+		Repeatable:       repeatable,
+		Squash:           d.Squash,
+	}, nil
+}
+
+// inEnvironment reports whether the migration runs in the given environment.
+func (pm PlannedMigration) inEnvironment(environment string) bool {
+	if len(pm.Environments) == 0 {
+		return true
+	}
+	for i := range pm.Environments {
+		if pm.Environments[i] == environment {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardPlan plans the pending migrations, leaving out the ones limited to
+// other environments than the given one. When no environment is given, the
+// migrations limited to environments are listed as skipped.
+func forwardPlan(src MigrationSource, mFiles migrationFiles, applied []MigrationRecord, environment string) (*Plan, error) {
+	var newest int64
+	for i := range applied {
+		if applied[i].Version > newest {
+			newest = applied[i].Version
+		}
+	}
+
+	plan := &Plan{Type: Forward, Migrations: make([]PlannedMigration, 0)}
+	for _, version := range pendingMigrations(mFiles, applied) {
+		pm, err := newPlannedMigration(src, version, mFiles[version])
+		if err != nil {
+			return nil, NewError(err)
+		}
+		if !pm.inEnvironment(environment) {
+			if environment == "" {
+				plan.Skipped = append(plan.Skipped, pm)
+			}
+			continue
+		}
+		pm.OutOfOrder = version < newest
+		plan.Migrations = append(plan.Migrations, pm)
+	}
+	return plan, nil
+}
+
+// warnSkipped notifies the migrations of the plan skipped as no environment
+// is selected, leaving out the ones not accepted by include.
+func (m *Migrator) warnSkipped(plan *Plan, include func(version int64) bool) {
+	for _, pm := range plan.Skipped {
+		if include != nil && !include(pm.Version) {
+			continue
+		}
+		m.notify(MigrationEvent{
+			Kind:    EventMigrationSkipped,
+			Version: pm.Version,
+			File:    pm.File,
+			Message: strings.Join(pm.Environments, ", "),
+		})
+	}
+}
+
+// checkRequires verifies that the versions required by the planned
+// migrations are applied or planned to run before them.
+func (p *Plan) checkRequires(applied []MigrationRecord) error {
+	available := make(map[int64]bool)
+	for i := range applied {
+		available[applied[i].Version] = true
+	}
+
+	missing := make([]string, 0)
+	for _, pm := range p.Migrations {
+		for _, required := range pm.Requires {
+			if !available[required] {
+				missing = append(
+					missing,
+					fmt.Sprintf("version: %v file: %s requires version: %v", pm.Version, pm.File, required),
+				)
+			}
+		}
+		available[pm.Version] = true
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	errx := errgo.New(
+		fmt.Errorf("required migrations are not applied:\n%s", strings.Join(missing, "\n")),
+	)
+	errx.Message = "required migrations are not applied"
+	for i := range missing {
+		errx.Details.Add(strconv.Itoa(i), missing[i])
+	}
+	return errx
+}
+
+// OutOfOrder returns the planned migrations older than the newest applied
+// migration.
+func (p *Plan) OutOfOrder() []PlannedMigration {
+	outOfOrder := make([]PlannedMigration, 0)
+	for i := range p.Migrations {
+		if p.Migrations[i].OutOfOrder {
+			outOfOrder = append(outOfOrder, p.Migrations[i])
+		}
+	}
+	return outOfOrder
+}
+
+// checkOutOfOrder applies the out of order policy to the plan, returning an
+// error listing the offending files when the policy is OutOfOrderError.
+func (m *Migrator) checkOutOfOrder(plan *Plan) error {
+	outOfOrder := plan.OutOfOrder()
+	switch m.cfg.Migration.OutOfOrder {
+	case OutOfOrderAllow:
+		return nil
+	case OutOfOrderWarn:
+		for i := range outOfOrder {
+			m.notify(MigrationEvent{
+				Kind:    EventOutOfOrder,
+				Version: outOfOrder[i].Version,
+				File:    outOfOrder[i].File,
+			})
+		}
+		return nil
+	case OutOfOrderError:
+		if len(outOfOrder) == 0 {
+			return nil
+		}
+		files := make([]string, len(outOfOrder))
+		for i := range outOfOrder {
+			files[i] = outOfOrder[i].File
+		}
+		errx := errgo.New(
+			fmt.Errorf(
+				"pending migrations are older than the newest applied migration:\n%s",
+				strings.Join(files, "\n"),
+			),
+		)
+		errx.Message = "out of order migrations"
+		for i := range outOfOrder {
+			errx.Details.Add(strconv.FormatInt(outOfOrder[i].Version, 10), outOfOrder[i].File)
+		}
+		return errx
+	default:
+		return NewError(
+			fmt.Errorf(
+				"unknown out_of_order policy: %s, expected %s, %s or %s",
+				m.cfg.Migration.OutOfOrder,
+				OutOfOrderAllow,
+				OutOfOrderWarn,
+				OutOfOrderError,
+			),
+		)
+	}
+}
@@ -0,0 +1,220 @@
+package pgmngr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testPlanSource() (MigrationSource, migrationFiles) {
+	fsys := fstest.MapFS{
+		"migrations/1600000001_a.up.sql":        {Data: []byte("SELECT 1;")},
+		"migrations/1600000002_b.up.sql":        {Data: []byte("SELECT 2;")},
+		"migrations/1600000003_c.no_txn.up.sql": {Data: []byte("SELECT 3;")},
+	}
+	mFiles := migrationFiles{
+		1600000003: "migrations/1600000003_c.no_txn.up.sql",
+		1600000001: "migrations/1600000001_a.up.sql",
+		1600000002: "migrations/1600000002_b.up.sql",
+	}
+	return NewFSSource(fsys, "migrations"), mFiles
+}
+
+func TestForwardPlan(t *testing.T) {
+	src, mFiles := testPlanSource()
+	applied := []MigrationRecord{
+		{Version: 1600000001},
+	}
+
+	plan, err := forwardPlan(src, mFiles, applied, "")
+	require.NoError(t, err)
+	require.Equal(t, Forward, plan.Type)
+	require.Equal(
+		t,
+		[]PlannedMigration{
+			{
+				Version:     1600000002,
+				Name:        "b",
+				File:        "migrations/1600000002_b.up.sql",
+				Checksum:    checksum([]byte("SELECT 2;")),
+				Transaction: true,
+			},
+			{
+				Version:     1600000003,
+				Name:        "c",
+				File:        "migrations/1600000003_c.no_txn.up.sql",
+				Checksum:    checksum([]byte("SELECT 3;")),
+				Transaction: false,
+			},
+		},
+		plan.Migrations,
+	)
+}
+
+func TestPlanDiff(t *testing.T) {
+	src, mFiles := testPlanSource()
+	expected, err := forwardPlan(src, mFiles, nil, "")
+	require.NoError(t, err)
+
+	t.Run("same plan", func(t *testing.T) {
+		actual, err := forwardPlan(src, mFiles, nil, "")
+		require.NoError(t, err)
+		require.Empty(t, expected.diff(actual))
+	})
+
+	t.Run("changed file", func(t *testing.T) {
+		actual, err := forwardPlan(src, mFiles, nil, "")
+		require.NoError(t, err)
+		actual.Migrations[1].Checksum = checksum([]byte("SELECT 4;"))
+		require.Equal(
+			t,
+			[]string{"version: 1600000002 file: migrations/1600000002_b.up.sql has changed"},
+			expected.diff(actual),
+		)
+	})
+
+	t.Run("applied and new migrations", func(t *testing.T) {
+		actual, err := forwardPlan(src, mFiles, []MigrationRecord{{Version: 1600000001}}, "")
+		require.NoError(t, err)
+		actual.Migrations = append(actual.Migrations, PlannedMigration{Version: 1600000004})
+		require.Equal(
+			t,
+			[]string{
+				"version: 1600000002 is planned in a different order",
+				"version: 1600000003 is planned in a different order",
+				"version: 1600000004 is pending but not planned",
+				"version: 1600000001 is planned but not pending",
+			},
+			expected.diff(actual),
+		)
+	})
+}
+
+func TestForwardPlan_directives(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1600000001_a.up.sql": {
+			Data: []byte("-- pgmngr: no-transaction, lock-timeout=5s\nSELECT 1;"),
+		},
+		"migrations/1600000002_b.up.sql": {
+			Data: []byte("-- pgmngr: environments=staging,prod\nSELECT 2;"),
+		},
+	}
+	mFiles := migrationFiles{
+		1600000001: "migrations/1600000001_a.up.sql",
+		1600000002: "migrations/1600000002_b.up.sql",
+	}
+	src := NewFSSource(fsys, "migrations")
+
+	plan, err := forwardPlan(src, mFiles, nil, "dev")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(plan.Migrations))
+	require.False(t, plan.Migrations[0].Transaction)
+	require.Equal(t, 5*time.Second, plan.Migrations[0].LockTimeout)
+	require.Empty(t, plan.Skipped)
+
+	plan, err = forwardPlan(src, mFiles, nil, "")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(plan.Migrations))
+	require.Equal(t, 1, len(plan.Skipped))
+	require.Equal(t, int64(1600000002), plan.Skipped[0].Version)
+
+	events := make([]MigrationEvent, 0)
+	m := &Migrator{OnEvent: func(e MigrationEvent) { events = append(events, e) }}
+	m.warnSkipped(plan, nil)
+	require.Equal(t, []MigrationEvent{{
+		Kind:    EventMigrationSkipped,
+		Version: 1600000002,
+		File:    "migrations/1600000002_b.up.sql",
+		Message: "staging, prod",
+	}}, events)
+
+	plan, err = forwardPlan(src, mFiles, nil, "prod")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(plan.Migrations))
+	require.True(t, plan.Migrations[1].Transaction)
+	require.Equal(t, []string{"staging", "prod"}, plan.Migrations[1].Environments)
+}
+
+func TestPlan_checkRequires(t *testing.T) {
+	plan := &Plan{
+		Type: Forward,
+		Migrations: []PlannedMigration{
+			{Version: 1600000002, File: "1600000002_b.up.sql", Requires: []int64{1600000001}},
+			{Version: 1600000003, File: "1600000003_c.up.sql", Requires: []int64{1600000002}},
+		},
+	}
+	require.NoError(t, plan.checkRequires([]MigrationRecord{{Version: 1600000001}}))
+
+	err := plan.checkRequires(nil)
+	require.Error(t, err)
+	require.Contains(
+		t,
+		err.Error(),
+		"version: 1600000002 file: 1600000002_b.up.sql requires version: 1600000001",
+	)
+
+	plan.Migrations[0].Requires = []int64{1600000003}
+	err = plan.checkRequires([]MigrationRecord{{Version: 1600000001}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "requires version: 1600000003")
+}
+
+func TestPlanSaveAndLoad(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "plan_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	src, mFiles := testPlanSource()
+	plan, err := forwardPlan(src, mFiles, nil, "")
+	require.NoError(t, err)
+
+	filePath := filepath.Join(tempDir, "plan.json")
+	err = plan.Save(filePath)
+	require.NoError(t, err)
+
+	loaded, err := LoadPlan(filePath)
+	require.NoError(t, err)
+	require.Equal(t, plan, loaded)
+}
+
+func TestCheckOutOfOrder(t *testing.T) {
+	src, mFiles := testPlanSource()
+	plan, err := forwardPlan(src, mFiles, []MigrationRecord{{Version: 1600000002}}, "")
+	require.NoError(t, err)
+
+	outOfOrder := plan.OutOfOrder()
+	require.Equal(t, 1, len(outOfOrder))
+	require.Equal(t, int64(1600000001), outOfOrder[0].Version)
+	require.False(t, plan.Migrations[1].OutOfOrder)
+
+	cfg := &Config{}
+	m := &Migrator{cfg: cfg}
+
+	cfg.Migration.OutOfOrder = OutOfOrderAllow
+	require.NoError(t, m.checkOutOfOrder(plan))
+
+	events := make([]MigrationEvent, 0)
+	m.OnEvent = func(e MigrationEvent) {
+		events = append(events, e)
+	}
+	cfg.Migration.OutOfOrder = OutOfOrderWarn
+	require.NoError(t, m.checkOutOfOrder(plan))
+	require.Equal(
+		t,
+		[]MigrationEvent{{Kind: EventOutOfOrder, Version: 1600000001, File: "migrations/1600000001_a.up.sql"}},
+		events,
+	)
+
+	cfg.Migration.OutOfOrder = OutOfOrderError
+	err = m.checkOutOfOrder(plan)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "migrations/1600000001_a.up.sql")
+
+	cfg.Migration.OutOfOrder = "sometimes"
+	require.Error(t, m.checkOutOfOrder(plan))
+}
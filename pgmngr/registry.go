@@ -0,0 +1,167 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/ParaServices/errgo"
+	"github.com/lib/pq"
+)
+
+// MigrationFunc is a migration written in Go, run inside the transaction of
+// the migration.
+type MigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
+// MigrationDBFunc is a migration written in Go that runs outside of a
+// transaction, e.g. to backfill a table in batches. It is given the *sql.DB
+// of the migrator, whose connections are taken from the pool and so hold
+// neither the migration lock nor the timeouts of the migration.
+type MigrationDBFunc func(ctx context.Context, db *sql.DB) error
+
+type goMigration struct {
+	version int64
+	name    string
+	up      MigrationFunc
+	down    MigrationFunc
+	upDB    MigrationDBFunc
+	downDB  MigrationDBFunc
+}
+
+func (g *goMigration) noTransaction() bool {
+	return g.upDB != nil
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[int64]*goMigration)
+)
+
+// Register registers a Go migration, usually from the init function of the
+// package holding it. Registered migrations are planned, ordered and tracked
+// together with the SQL migration files. down may be nil when the migration
+// cannot be rolled back. Register panics when the version is registered
+// twice or up is nil.
+func Register(version int64, name string, up, down MigrationFunc) {
+	if up == nil {
+		panic(fmt.Sprintf("pgmngr: Register up migration of version %v is nil", version))
+	}
+	register(&goMigration{version: version, name: name, up: up, down: down})
+}
+
+// RegisterNoTx registers a Go migration like Register, but runs it outside of
+// a transaction with the database handle of the migrator.
+func RegisterNoTx(version int64, name string, up, down MigrationDBFunc) {
+	if up == nil {
+		panic(fmt.Sprintf("pgmngr: RegisterNoTx up migration of version %v is nil", version))
+	}
+	register(&goMigration{version: version, name: name, upDB: up, downDB: down})
+}
+
+func register(g *goMigration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if g.version <= 0 {
+		panic(fmt.Sprintf("pgmngr: invalid migration version %v", g.version))
+	}
+	if _, dup := registry[g.version]; dup {
+		panic(fmt.Sprintf("pgmngr: migration version %v registered twice", g.version))
+	}
+	registry[g.version] = g
+}
+
+func registeredMigration(version int64) (*goMigration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	g, ok := registry[version]
+	return g, ok
+}
+
+// goMigrationFile returns the name standing in for the file of a Go
+// migration, e.g. `1600000000_backfill_users.up.go`.
+func goMigrationFile(mType MigrationType, version int64, name string) string {
+	if mType == Rollback {
+		return fmt.Sprintf("%v_%s.down.go", version, name)
+	}
+	return fmt.Sprintf("%v_%s.up.go", version, name)
+}
+
+func isGoMigrationFile(filePath string) bool {
+	return filepath.Ext(filePath) == ".go"
+}
+
+// mergeGoMigrations adds the registered Go migrations to the migration files,
+// leaving out those without a down function for Rollback.
+func mergeGoMigrations(mType MigrationType, mFiles migrationFiles) (migrationFiles, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for version, g := range registry {
+		if mType == Rollback && g.down == nil && g.downDB == nil {
+			continue
+		}
+		if filePath, ok := mFiles[version]; ok {
+			return nil, NewError(
+				fmt.Errorf("version: %v is both a registered Go migration and file: %s", version, filePath),
+			)
+		}
+		mFiles[version] = goMigrationFile(mType, version, g.name)
+	}
+
+	return mFiles, nil
+}
+
+// migrationFiles returns the migration files of the source merged with the
+// registered Go migrations.
+func (m *Migrator) migrationFiles(mType MigrationType) (migrationFiles, error) {
+	mFiles, err := getMigrationFiles(mType, m.source())
+	if err != nil {
+		return nil, NewError(err)
+	}
+	return mergeGoMigrations(mType, mFiles)
+}
+
+// runGoMigration runs the function of a registered Go migration, within tx
+// unless it was registered with RegisterNoTx.
+func (m *Migrator) runGoMigration(ctx context.Context, tx *sql.Tx, mType MigrationType, version int64) error {
+	g, ok := registeredMigration(version)
+	if !ok {
+		return NewError(fmt.Errorf("Go migration for version: %v is not registered", version))
+	}
+
+	switch {
+	case mType == Forward && g.up != nil:
+		return g.up(ctx, tx)
+	case mType == Forward:
+		return g.upDB(ctx, m.db)
+	case g.down != nil:
+		return g.down(ctx, tx)
+	case g.downDB != nil:
+		return g.downDB(ctx, m.db)
+	default:
+		return NewError(fmt.Errorf("Go migration for version: %v has no down migration", version))
+	}
+}
+
+// goMigrationError returns the error of a Go migration with the name standing
+// in for its file, keeping the postgres error it wraps, if any, so lock
+// contention is retried like for SQL migrations.
+func goMigrationError(filePath string, err error) error {
+	errx := errgo.New(fmt.Errorf("%s: %v", filePath, err))
+	errx.Message = fmt.Sprintf("migration failed in file: %s", filePath)
+	errx.Details.Add("file", filePath)
+	var pqErr *pq.Error
+	var errgoErr *errgo.Error
+	switch {
+	case errors.As(err, &pqErr):
+		errx.AddPQError(pqErr)
+	case errors.As(err, &errgoErr) && errgoErr.PQError != nil:
+		errx.AddPQError(errgoErr.PQError.Error)
+	}
+	return errx
+}
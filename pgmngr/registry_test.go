@@ -0,0 +1,109 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// unregister removes registered Go migrations so they do not leak into other
+// tests.
+func unregister(versions ...int64) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, version := range versions {
+		delete(registry, version)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	defer unregister(1600000101, 1600000102)
+
+	noop := func(ctx context.Context, tx *sql.Tx) error { return nil }
+	Register(1600000101, "backfill", noop, nil)
+	RegisterNoTx(1600000102, "batched", func(ctx context.Context, db *sql.DB) error { return nil }, nil)
+
+	require.Panics(t, func() { Register(1600000101, "duplicate", noop, nil) })
+	require.Panics(t, func() { Register(1600000103, "no_up", nil, noop) })
+	require.Panics(t, func() { Register(0, "no_version", noop, nil) })
+
+	mFiles, err := mergeGoMigrations(Forward, migrationFiles{1600000100: "1600000100_a.up.sql"})
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		migrationFiles{
+			1600000100: "1600000100_a.up.sql",
+			1600000101: "1600000101_backfill.up.go",
+			1600000102: "1600000102_batched.up.go",
+		},
+		mFiles,
+	)
+
+	mFiles, err = mergeGoMigrations(Rollback, migrationFiles{})
+	require.NoError(t, err)
+	require.Empty(t, mFiles)
+
+	_, err = mergeGoMigrations(Forward, migrationFiles{1600000101: "1600000101_backfill.up.sql"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "version: 1600000101 is both a registered Go migration")
+
+	pm, err := newPlannedMigration(nil, 1600000102, "1600000102_batched.up.go")
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		PlannedMigration{Version: 1600000102, Name: "batched", File: "1600000102_batched.up.go"},
+		pm,
+	)
+}
+
+func TestMigrator_goMigrations(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "go_migrations")
+
+	writeTestMigration(t, tempDir, 1600000201, "a", "CREATE TABLE public.go_a(id INT);", "DROP TABLE public.go_a;")
+
+	defer unregister(1600000202, 1600000203)
+	Register(
+		1600000202,
+		"insert_a",
+		func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO public.go_a VALUES (1)")
+			return err
+		},
+		func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "DELETE FROM public.go_a")
+			return err
+		},
+	)
+	RegisterNoTx(
+		1600000203,
+		"insert_a_batched",
+		func(ctx context.Context, db *sql.DB) error {
+			_, err := db.ExecContext(ctx, "INSERT INTO public.go_a VALUES (2)")
+			return err
+		},
+		nil,
+	)
+
+	results, err := m.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(results))
+	require.True(t, results[1].Transaction)
+	require.False(t, results[2].Transaction)
+
+	var count int
+	err = m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM public.go_a").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	record, err := m.Show(ctx, 1600000202)
+	require.NoError(t, err)
+	require.Equal(t, "insert_a", record.Name)
+	require.Equal(t, "1600000202_insert_a.up.go", record.FileName)
+
+	_, err = m.Down(ctx, 1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "down migration file for version: 1600000203 not found")
+}
@@ -0,0 +1,147 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// repeatable migration files, e.g. `R__users_view.sql`, are re-applied after
+// the versioned migrations whenever their contents change.
+var isRepeatableMigrationRegex = regexp.MustCompile(`^R__(.+)\.sql$`)
+
+func isRepeatableMigrationFile(filePath string) bool {
+	return isRepeatableMigrationRegex.MatchString(filepath.Base(filePath))
+}
+
+// getNameFromRepeatableFileName returns the name of a repeatable migration,
+// e.g. `users_view` for `R__users_view.sql`.
+func getNameFromRepeatableFileName(fileName string) string {
+	matches := isRepeatableMigrationRegex.FindStringSubmatch(fileName)
+	if matches == nil {
+		return ""
+	}
+	return matches[1]
+}
+
+// repeatableTable returns the name of the table tracking the repeatable
+// migrations, next to the schema migrations table.
+func repeatableTable(cfg *Config) string {
+	return cfg.Migration.Table.Name + "_repeatable"
+}
+
+// getRepeatableMigrationFiles returns the repeatable migration files of the
+// source ordered by name.
+func getRepeatableMigrationFiles(src MigrationSource) ([]string, error) {
+	files, err := src.Files()
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	rFiles := make([]string, 0)
+	for _, path := range files {
+		if isRepeatableMigrationFile(path) {
+			rFiles = append(rFiles, path)
+		}
+	}
+	sort.Slice(
+		rFiles,
+		func(i, j int) bool {
+			return filepath.Base(rFiles[i]) < filepath.Base(rFiles[j])
+		},
+	)
+
+	return rFiles, nil
+}
+
+func ensureTableRepeatableMigrations(ctx context.Context, q execer, cfg *Config) error {
+	_, err := q.ExecContext(
+		ctx,
+		stmntCreateRepeatableMigrationsTable,
+		cfg.Migration.Table.Schema,
+		repeatableTable(cfg),
+	)
+	if err != nil {
+		return NewError(err)
+	}
+	return nil
+}
+
+// getAppliedRepeatableMigrations returns the checksums of the last run of the
+// repeatable migrations by name.
+func getAppliedRepeatableMigrations(ctx context.Context, q execer, cfg *Config) (map[string]string, error) {
+	applied := make(map[string]string)
+
+	exists := false
+	err := q.QueryRowContext(
+		ctx,
+		stmntSchemaMigrationTableExists,
+		cfg.Migration.Table.Schema,
+		repeatableTable(cfg),
+	).Scan(&exists)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := q.QueryContext(
+		ctx,
+		stmntAppliedRepeatableMigrations,
+		cfg.Migration.Table.Schema,
+		repeatableTable(cfg),
+	)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var sum sql.NullString
+		err = rows.Scan(&name, &sum)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		applied[name] = sum.String
+	}
+	if err = rows.Err(); err != nil {
+		return nil, NewError(err)
+	}
+
+	return applied, nil
+}
+
+// repeatablePlan plans the repeatable migrations that are new or changed
+// since their last run.
+func (m *Migrator) repeatablePlan(ctx context.Context, q execer) ([]PlannedMigration, error) {
+	rFiles, err := getRepeatableMigrationFiles(m.source())
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	applied, err := getAppliedRepeatableMigrations(ctx, q, m.cfg)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	planned := make([]PlannedMigration, 0)
+	for _, filePath := range rFiles {
+		pm, err := newPlannedMigration(m.source(), 0, filePath)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		if !pm.inEnvironment(m.Environment) {
+			continue
+		}
+		if sum, ok := applied[pm.Name]; ok && sum == pm.Checksum {
+			continue
+		}
+		planned = append(planned, pm)
+	}
+
+	return planned, nil
+}
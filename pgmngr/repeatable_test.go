@@ -0,0 +1,89 @@
+package pgmngr
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRepeatableMigrationFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1600000001_a.up.sql":   {Data: []byte("SELECT 1;")},
+		"migrations/1600000001_a.down.sql": {Data: []byte("SELECT 1;")},
+		"migrations/R__users_view.sql":     {Data: []byte("CREATE OR REPLACE VIEW users_v AS SELECT 1;")},
+		"migrations/R__accounts_fn.sql":    {Data: []byte("SELECT 2;")},
+	}
+	src := NewFSSource(fsys, "migrations")
+
+	rFiles, err := getRepeatableMigrationFiles(src)
+	require.NoError(t, err)
+	require.Equal(t, []string{"migrations/R__accounts_fn.sql", "migrations/R__users_view.sql"}, rFiles)
+
+	mFiles, err := getMigrationFiles(Forward, src)
+	require.NoError(t, err)
+	require.Equal(t, migrationFiles{1600000001: "migrations/1600000001_a.up.sql"}, mFiles)
+
+	pm, err := newPlannedMigration(src, 0, "migrations/R__users_view.sql")
+	require.NoError(t, err)
+	require.Equal(t, "users_view", pm.Name)
+	require.True(t, pm.Repeatable)
+	require.True(t, pm.Transaction)
+}
+
+func TestPlan_diffRepeatable(t *testing.T) {
+	expected := &Plan{
+		Type:       Forward,
+		Repeatable: []PlannedMigration{{File: "R__a.sql", Checksum: "1"}, {File: "R__b.sql", Checksum: "2"}},
+	}
+	require.Empty(t, expected.diff(expected))
+
+	actual := &Plan{
+		Type:       Forward,
+		Repeatable: []PlannedMigration{{File: "R__a.sql", Checksum: "3"}, {File: "R__c.sql", Checksum: "4"}},
+	}
+	require.Equal(
+		t,
+		[]string{
+			"repeatable file: R__a.sql has changed",
+			"repeatable file: R__c.sql is pending but not planned",
+			"repeatable file: R__b.sql is planned but not pending",
+		},
+		expected.diff(actual),
+	)
+}
+
+func TestMigrator_repeatable(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "repeatable")
+
+	writeTestMigration(t, tempDir, 1600000001, "a", "CREATE TABLE public.repeatable_a(id INT);", "DROP TABLE public.repeatable_a;")
+	viewFile := filepath.Join(tempDir, "R__repeatable_v.sql")
+	err := ioutil.WriteFile(viewFile, []byte("CREATE OR REPLACE VIEW public.repeatable_v AS SELECT id FROM public.repeatable_a;"), 0644)
+	require.NoError(t, err)
+
+	results, err := m.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+	require.True(t, results[1].Repeatable)
+
+	plan, err := m.Plan(ctx)
+	require.NoError(t, err)
+	require.True(t, plan.Empty())
+
+	err = ioutil.WriteFile(viewFile, []byte("CREATE OR REPLACE VIEW public.repeatable_v AS SELECT id, 1 AS one FROM public.repeatable_a;"), 0644)
+	require.NoError(t, err)
+
+	plan, err = m.Plan(ctx)
+	require.NoError(t, err)
+	require.Empty(t, plan.Migrations)
+	require.Equal(t, 1, len(plan.Repeatable))
+
+	results, err = m.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(results))
+	require.Equal(t, "repeatable_v", results[0].Name)
+}
@@ -0,0 +1,795 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// schemaDumpHeader is written at the top of every schema dump. Function
+// bodies are not validated as they might refer to tables created later on,
+// for the transaction only so pooled connections are left untouched.
+const schemaDumpHeader = `-- Schema dumped by pgmngr.
+
+SET LOCAL check_function_bodies = false;
+`
+
+// trackingTables returns the `schema.table` names of the tables tracking the
+// migrations, which are left out of schema dumps.
+func trackingTables(cfg *Config) []string {
+	return []string{
+		cfg.Migration.Table.Schema + "." + cfg.Migration.Table.Name,
+		cfg.Migration.Table.Schema + "." + repeatableTable(cfg),
+	}
+}
+
+// schemaAppliedPrefix prefixes the comment lines of a schema dump listing
+// the applied migrations, one version per line so a new migration shows up
+// as a single added line.
+const schemaAppliedPrefix = "-- pgmngr-applied: "
+
+// DumpSchema returns the DDL of the database, without the tables tracking
+// the migrations, followed by comments listing the applied migrations.
+// Objects are ordered by name, and after the objects they depend on, so
+// dumps only differ when the schemas do.
+func (m *Migrator) DumpSchema(ctx context.Context) (string, error) {
+	var dump string
+	err := m.session(ctx, false, func(conn *sql.Conn) error {
+		var err error
+		dump, err = dumpSchema(ctx, conn, trackingTables(m.cfg))
+		if err != nil {
+			return NewError(err)
+		}
+
+		exists, err := schemaMigrationsTableExists(ctx, conn, m.cfg)
+		if err != nil || !exists {
+			return err
+		}
+		applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+		if err != nil {
+			return NewError(err)
+		}
+		if len(applied) == 0 {
+			return nil
+		}
+
+		var builder strings.Builder
+		builder.WriteString("\n-- Applied migrations\n\n")
+		for i := range applied {
+			fmt.Fprintf(&builder, "%s%v\n", schemaAppliedPrefix, applied[i].Version)
+		}
+		dump += builder.String()
+		return nil
+	})
+	if err != nil {
+		return "", NewError(err)
+	}
+	return dump, nil
+}
+
+// schemaAppliedVersions returns the applied migrations listed by a schema
+// dump.
+func schemaAppliedVersions(dump []byte) ([]int64, error) {
+	versions := make([]int64, 0)
+	for i, line := range strings.Split(string(dump), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, schemaAppliedPrefix) {
+			continue
+		}
+		version, err := strconv.ParseInt(strings.TrimPrefix(line, schemaAppliedPrefix), 10, 64)
+		if err != nil {
+			return nil, NewError(fmt.Errorf("line %v: invalid applied migration: %v", i+1, err))
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// LoadSchema loads a schema written by DumpSchema into an empty database,
+// within a single transaction, and records the migrations it lists as
+// applied without running them.
+func (m *Migrator) LoadSchema(ctx context.Context, filePath string) ([]MigrationResult, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	stmnts, err := splitStatements(string(b))
+	if err != nil {
+		return nil, NewError(fmt.Errorf("%s: %v", filePath, err))
+	}
+
+	versions, err := schemaAppliedVersions(b)
+	if err != nil {
+		return nil, NewError(fmt.Errorf("%s: %v", filePath, err))
+	}
+
+	return m.migrate(ctx, func(conn *sql.Conn) ([]MigrationResult, error) {
+		applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		if len(applied) > 0 {
+			return nil, NewError(
+				fmt.Errorf("the schema can only be loaded into a database without applied migrations"),
+			)
+		}
+
+		mFiles, err := m.migrationFiles(Forward)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		for i := range stmnts {
+			err = execStatement(ctx, conn, tx, stmnts[i])
+			if err != nil {
+				tx.Rollback()
+				return nil, statementError(filePath, stmnts[i], err)
+			}
+		}
+
+		results := make([]MigrationResult, 0)
+		for _, version := range versions {
+			pm := PlannedMigration{Version: version, Transaction: true}
+			if mFile, ok := mFiles[version]; ok {
+				pm, err = newPlannedMigration(m.source(), version, mFile)
+				if err != nil {
+					tx.Rollback()
+					return nil, NewError(err)
+				}
+			}
+			err = m.recordMigration(ctx, tx, pm, pm.Checksum, 0)
+			if err != nil {
+				tx.Rollback()
+				return nil, NewError(err)
+			}
+			results = append(results, MigrationResult{PlannedMigration: pm, Type: Forward})
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, NewError(err)
+		}
+
+		for i := range results {
+			m.notify(MigrationEvent{
+				Kind:    EventMigrationBaselined,
+				Version: results[i].Version,
+				File:    results[i].File,
+			})
+		}
+
+		return results, nil
+	})
+}
+
+// dumpSchema writes the DDL of the non-system schemas of the database,
+// leaving out the excluded `schema.table` tables. The catalog is read within
+// a single read-only transaction so the dump is consistent, and every name is
+// schema qualified.
+func dumpSchema(ctx context.Context, conn *sql.Conn, exclude []string) (string, error) {
+	d, err := readSchema(ctx, conn, exclude)
+	if err != nil {
+		return "", NewError(err)
+	}
+	return d.String(), nil
+}
+
+// readSchema reads the catalog like dumpSchema, returning the dump along
+// with the tables it includes.
+func readSchema(ctx context.Context, conn *sql.Conn, exclude []string) (*schemaDump, error) {
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, NewError(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, stmntSetConfig, "search_path", "pg_catalog", true)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	d := &schemaDump{ctx: ctx, tx: tx, exclude: exclude, tablesByName: make(map[string]*dumpedTable)}
+	if exclude == nil {
+		d.exclude = make([]string, 0)
+	}
+
+	sections := []func() error{
+		d.schemas,
+		d.extensions,
+		d.types,
+		d.sequences,
+		d.functions,
+		d.functionRanges,
+		d.tables,
+		d.sequenceOwners,
+		d.constraints,
+		d.indexes,
+		d.views,
+		d.viewIndexes,
+		d.triggers,
+		d.comments,
+	}
+	d.WriteString(schemaDumpHeader)
+	for _, section := range sections {
+		err = section()
+		if err != nil {
+			return nil, NewError(err)
+		}
+	}
+
+	return d, nil
+}
+
+// schemaDump holds the state of dumpSchema while its sections are written.
+type schemaDump struct {
+	strings.Builder
+	ctx     context.Context
+	tx      *sql.Tx
+	exclude []string
+
+	schemaNames []string
+	tableOIDs   []int64
+	viewOIDs    []int64
+	// matViewOIDs are the materialized views among viewOIDs.
+	matViewOIDs []int64
+	// functionRangeTypes are the range types using functions of the
+	// database, created once the functions are.
+	functionRangeTypes []string
+	// functionIdentities are the names of the functions with the types of
+	// their arguments, by the name and arguments they are created with.
+	functionIdentities map[string]string
+	// viewDependencies are the tables, views and functions the views depend
+	// on, by view, as objects of the dump, e.g. `table public.people`.
+	viewDependencies map[string][]string
+	// tablesByName are the dumped tables by name.
+	tablesByName map[string]*dumpedTable
+}
+
+// section writes the title of a section followed by its statements, nothing
+// being written when there are none.
+func (d *schemaDump) section(title string, stmnts []string) {
+	if len(stmnts) == 0 {
+		return
+	}
+	fmt.Fprintf(&d.Builder, "\n-- %s\n\n", title)
+	for i := range stmnts {
+		d.WriteString(stmnts[i])
+		d.WriteString("\n")
+	}
+}
+
+// query runs a dump query, calling scan for every row.
+func (d *schemaDump) query(stmnt string, scan func(rows *sql.Rows) error, args ...interface{}) error {
+	rows, err := d.tx.QueryContext(d.ctx, stmnt, args...)
+	if err != nil {
+		return NewError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return NewError(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return NewError(err)
+	}
+	return nil
+}
+
+func (d *schemaDump) schemas() error {
+	stmnts := make([]string, 0)
+	err := d.query(stmntDumpSchemas, func(rows *sql.Rows) error {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return err
+		}
+		d.schemaNames = append(d.schemaNames, name)
+		stmnts = append(stmnts, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;", pq.QuoteIdentifier(name)))
+		return nil
+	})
+	if err != nil {
+		return NewError(err)
+	}
+	d.section("Schemas", stmnts)
+	return nil
+}
+
+func (d *schemaDump) extensions() error {
+	stmnts := make([]string, 0)
+	err := d.query(stmntDumpExtensions, func(rows *sql.Rows) error {
+		var name, schema string
+		err := rows.Scan(&name, &schema)
+		if err != nil {
+			return err
+		}
+		stmnts = append(stmnts, fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s WITH SCHEMA %s;", name, schema))
+		return nil
+	})
+	if err != nil {
+		return NewError(err)
+	}
+	d.section("Extensions", stmnts)
+	return nil
+}
+
+func (d *schemaDump) types() error {
+	stmnts := make([]string, 0)
+	err := d.query(stmntDumpEnums, func(rows *sql.Rows) error {
+		var name, labels string
+		err := rows.Scan(&name, &labels)
+		if err != nil {
+			return err
+		}
+		stmnts = append(stmnts, fmt.Sprintf("CREATE TYPE %s AS ENUM (%s);", name, labels))
+		return nil
+	}, pq.Array(d.schemaNames))
+	if err != nil {
+		return NewError(err)
+	}
+
+	err = d.query(stmntDumpDomains, func(rows *sql.Rows) error {
+		var name, baseType, defaultValue, constraints string
+		var notNull bool
+		err := rows.Scan(&name, &baseType, &notNull, &defaultValue, &constraints)
+		if err != nil {
+			return err
+		}
+		stmnt := fmt.Sprintf("CREATE DOMAIN %s AS %s", name, baseType)
+		if defaultValue != "" {
+			stmnt += " DEFAULT " + defaultValue
+		}
+		if notNull {
+			stmnt += " NOT NULL"
+		}
+		if constraints != "" {
+			stmnt += " " + constraints
+		}
+		stmnts = append(stmnts, stmnt+";")
+		return nil
+	}, pq.Array(d.schemaNames))
+	if err != nil {
+		return NewError(err)
+	}
+
+	err = d.query(stmntDumpRanges, func(rows *sql.Rows) error {
+		var name, subtype, opclass, collation, canonical, subtypeDiff string
+		var builtin bool
+		err := rows.Scan(&name, &subtype, &opclass, &collation, &canonical, &subtypeDiff, &builtin)
+		if err != nil {
+			return err
+		}
+		stmnt := fmt.Sprintf("CREATE TYPE %s AS RANGE (SUBTYPE = %s", name, subtype)
+		if opclass != "" {
+			stmnt += ", SUBTYPE_OPCLASS = " + opclass
+		}
+		if collation != "" {
+			stmnt += ", COLLATION = " + collation
+		}
+		if canonical != "" {
+			stmnt += ", CANONICAL = " + canonical
+		}
+		if subtypeDiff != "" {
+			stmnt += ", SUBTYPE_DIFF = " + subtypeDiff
+		}
+		stmnt += ");"
+		if !builtin {
+			d.functionRangeTypes = append(d.functionRangeTypes, stmnt)
+			return nil
+		}
+		stmnts = append(stmnts, stmnt)
+		return nil
+	}, pq.Array(d.schemaNames))
+	if err != nil {
+		return NewError(err)
+	}
+
+	// composite types follow the composite types of their attributes
+	oids := make([]int64, 0)
+	composites := make(map[int64]string)
+	dependencies := make(map[int64][]int64)
+	err = d.query(stmntDumpCompositeTypes, func(rows *sql.Rows) error {
+		var oid int64
+		var name, attributes string
+		var attributeTypes []int64
+		err := rows.Scan(&oid, &name, &attributes, pq.Array(&attributeTypes))
+		if err != nil {
+			return err
+		}
+		oids = append(oids, oid)
+		composites[oid] = fmt.Sprintf("CREATE TYPE %s AS (%s);", name, attributes)
+		dependencies[oid] = attributeTypes
+		return nil
+	}, pq.Array(d.schemaNames))
+	if err != nil {
+		return NewError(err)
+	}
+	stmnts = append(stmnts, dependencyOrder(oids, dependencies, composites)...)
+
+	d.section("Types", stmnts)
+	return nil
+}
+
+// functionRanges writes the range types whose canonical or subtype difference
+// function is a function of the database.
+func (d *schemaDump) functionRanges() error {
+	d.section("Range types", d.functionRangeTypes)
+	return nil
+}
+
+func (d *schemaDump) sequences() error {
+	stmnts := make([]string, 0)
+	err := d.query(stmntDumpSequences, func(rows *sql.Rows) error {
+		var name, dataType string
+		var start, min, max, increment, cache int64
+		var cycle bool
+		err := rows.Scan(&name, &dataType, &start, &min, &max, &increment, &cache, &cycle)
+		if err != nil {
+			return err
+		}
+		stmnt := fmt.Sprintf(
+			"CREATE SEQUENCE %s AS %s INCREMENT BY %v MINVALUE %v MAXVALUE %v START WITH %v CACHE %v",
+			name, dataType, increment, min, max, start, cache,
+		)
+		if cycle {
+			stmnt += " CYCLE"
+		}
+		stmnts = append(stmnts, stmnt+";")
+		return nil
+	}, pq.Array(d.schemaNames))
+	if err != nil {
+		return NewError(err)
+	}
+	d.section("Sequences", stmnts)
+	return nil
+}
+
+func (d *schemaDump) functions() error {
+	stmnts := make([]string, 0)
+	d.functionIdentities = make(map[string]string)
+	err := d.query(stmntDumpFunctions, func(rows *sql.Rows) error {
+		var def, identity string
+		err := rows.Scan(&def, &identity)
+		if err != nil {
+			return err
+		}
+		if _, names := schemaObjectNames(def); names != nil {
+			d.functionIdentities[names[0]] = identity
+		}
+		stmnts = append(stmnts, strings.TrimRight(def, "\n")+";\n")
+		return nil
+	}, pq.Array(d.schemaNames))
+	if err != nil {
+		return NewError(err)
+	}
+	d.section("Functions", stmnts)
+	return nil
+}
+
+type dumpedTable struct {
+	oid       int64
+	name      string
+	partKey   string
+	parent    string
+	partBound string
+	columns   []*dumpedColumn
+}
+
+// column returns the column of the table with the given name, or nil.
+func (t *dumpedTable) column(name string) *dumpedColumn {
+	for _, c := range t.columns {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+type dumpedColumn struct {
+	name         string
+	dataType     string
+	notNull      bool
+	defaultValue string
+	identity     string
+	generated    string
+	collation    string
+}
+
+// definition returns the column as written in CREATE TABLE.
+func (c *dumpedColumn) definition() string {
+	column := c.name + " " + c.dataType
+	if c.collation != "" {
+		column += " COLLATE " + c.collation
+	}
+	switch {
+	case c.generated == "s":
+		column += fmt.Sprintf(" GENERATED ALWAYS AS (%s) STORED", c.defaultValue)
+	case c.identity == "a":
+		column += " GENERATED ALWAYS AS IDENTITY"
+	case c.identity == "d":
+		column += " GENERATED BY DEFAULT AS IDENTITY"
+	case c.defaultValue != "":
+		column += " DEFAULT " + c.defaultValue
+	}
+	if c.notNull {
+		column += " NOT NULL"
+	}
+	return column
+}
+
+func (d *schemaDump) tables() error {
+	tables := make([]*dumpedTable, 0)
+	byOID := make(map[int64]*dumpedTable)
+	err := d.query(stmntDumpTables, func(rows *sql.Rows) error {
+		t := &dumpedTable{}
+		err := rows.Scan(&t.oid, &t.name, &t.partKey, &t.parent, &t.partBound)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, t)
+		byOID[t.oid] = t
+		d.tablesByName[t.name] = t
+		d.tableOIDs = append(d.tableOIDs, t.oid)
+		return nil
+	}, pq.Array(d.schemaNames), pq.Array(d.exclude))
+	if err != nil {
+		return NewError(err)
+	}
+
+	err = d.query(stmntDumpColumns, func(rows *sql.Rows) error {
+		var oid int64
+		c := &dumpedColumn{}
+		var local bool
+		err := rows.Scan(
+			&oid, &c.name, &c.dataType, &c.notNull, &c.defaultValue, &c.identity, &c.generated, &c.collation, &local,
+		)
+		if err != nil {
+			return err
+		}
+		t := byOID[oid]
+		if !local {
+			// inherited from the parent table
+			return nil
+		}
+		t.columns = append(t.columns, c)
+		return nil
+	}, pq.Array(d.tableOIDs))
+	if err != nil {
+		return NewError(err)
+	}
+
+	stmnts := make([]string, 0, len(tables))
+	for _, t := range tables {
+		var stmnt string
+		if t.parent != "" && t.partBound != "" {
+			stmnt = fmt.Sprintf("CREATE TABLE %s PARTITION OF %s %s", t.name, t.parent, t.partBound)
+		} else {
+			stmnt = fmt.Sprintf("CREATE TABLE %s (", t.name)
+			if len(t.columns) > 0 {
+				columns := make([]string, len(t.columns))
+				for i := range t.columns {
+					columns[i] = t.columns[i].definition()
+				}
+				stmnt += "\n    " + strings.Join(columns, ",\n    ") + "\n"
+			}
+			stmnt += ")"
+			if t.parent != "" {
+				stmnt += " INHERITS (" + t.parent + ")"
+			}
+		}
+		if t.partKey != "" {
+			stmnt += " PARTITION BY " + t.partKey
+		}
+		stmnts = append(stmnts, stmnt+";\n")
+	}
+	d.section("Tables", stmnts)
+	return nil
+}
+
+func (d *schemaDump) sequenceOwners() error {
+	stmnts := make([]string, 0)
+	err := d.query(stmntDumpSequenceOwners, func(rows *sql.Rows) error {
+		var sequence, column string
+		err := rows.Scan(&sequence, &column)
+		if err != nil {
+			return err
+		}
+		stmnts = append(stmnts, fmt.Sprintf("ALTER SEQUENCE %s OWNED BY %s;", sequence, column))
+		return nil
+	}, pq.Array(d.schemaNames), pq.Array(d.exclude))
+	if err != nil {
+		return NewError(err)
+	}
+	d.section("Sequence ownership", stmnts)
+	return nil
+}
+
+func (d *schemaDump) constraints() error {
+	stmnts := make([]string, 0)
+	err := d.query(stmntDumpConstraints, func(rows *sql.Rows) error {
+		var table, name, def string
+		err := rows.Scan(&table, &name, &def)
+		if err != nil {
+			return err
+		}
+		stmnts = append(stmnts, fmt.Sprintf("ALTER TABLE ONLY %s ADD CONSTRAINT %s %s;", table, name, def))
+		return nil
+	}, pq.Array(d.tableOIDs))
+	if err != nil {
+		return NewError(err)
+	}
+	d.section("Constraints", stmnts)
+	return nil
+}
+
+func (d *schemaDump) indexes() error {
+	return d.indexSection("Indexes", d.tableOIDs)
+}
+
+// viewIndexes writes the indexes of the materialized views, which are created
+// after the tables.
+func (d *schemaDump) viewIndexes() error {
+	return d.indexSection("Materialized view indexes", d.matViewOIDs)
+}
+
+func (d *schemaDump) indexSection(title string, oids []int64) error {
+	stmnts := make([]string, 0)
+	err := d.query(stmntDumpIndexes, func(rows *sql.Rows) error {
+		var def string
+		err := rows.Scan(&def)
+		if err != nil {
+			return err
+		}
+		stmnts = append(stmnts, def+";")
+		return nil
+	}, pq.Array(oids))
+	if err != nil {
+		return NewError(err)
+	}
+	d.section(title, stmnts)
+	return nil
+}
+
+func (d *schemaDump) views() error {
+	stmnts := make(map[int64]string)
+	objects := make(map[int64]string)
+	for name, t := range d.tablesByName {
+		objects[t.oid] = "table " + name
+	}
+	err := d.query(stmntDumpViews, func(rows *sql.Rows) error {
+		var oid int64
+		var name, def string
+		var materialized bool
+		err := rows.Scan(&oid, &name, &materialized, &def)
+		if err != nil {
+			return err
+		}
+		d.viewOIDs = append(d.viewOIDs, oid)
+		objects[oid] = "view " + name
+		def = strings.TrimRight(strings.TrimSpace(def), ";")
+		if materialized {
+			d.matViewOIDs = append(d.matViewOIDs, oid)
+			stmnts[oid] = fmt.Sprintf("CREATE MATERIALIZED VIEW %s AS\n%s\nWITH NO DATA;\n", name, def)
+			return nil
+		}
+		stmnts[oid] = fmt.Sprintf("CREATE VIEW %s AS\n%s;\n", name, def)
+		return nil
+	}, pq.Array(d.schemaNames))
+	if err != nil {
+		return NewError(err)
+	}
+
+	dependencies := make(map[int64][]int64)
+	err = d.query(stmntDumpViewDependencies, func(rows *sql.Rows) error {
+		var oid, dependency int64
+		err := rows.Scan(&oid, &dependency)
+		if err != nil {
+			return err
+		}
+		dependencies[oid] = append(dependencies[oid], dependency)
+		return nil
+	}, pq.Array(d.viewOIDs))
+	if err != nil {
+		return NewError(err)
+	}
+
+	d.viewDependencies = make(map[string][]string)
+	for oid, oids := range dependencies {
+		for _, dependency := range oids {
+			if object, ok := objects[dependency]; ok {
+				d.viewDependencies[objects[oid]] = append(d.viewDependencies[objects[oid]], object)
+			}
+		}
+	}
+	err = d.query(stmntDumpViewFunctions, func(rows *sql.Rows) error {
+		var oid int64
+		var function string
+		err := rows.Scan(&oid, &function)
+		if err != nil {
+			return err
+		}
+		d.viewDependencies[objects[oid]] = append(d.viewDependencies[objects[oid]], "function "+function)
+		return nil
+	}, pq.Array(d.viewOIDs))
+	if err != nil {
+		return NewError(err)
+	}
+
+	d.section("Views", dependencyOrder(d.viewOIDs, dependencies, stmnts))
+	return nil
+}
+
+// dependencyOrder returns the statements of the objects, given in name
+// order, with every object following the objects it depends on. The order
+// only depends on the names and dependencies of the objects, not on their
+// oids, so dumps of different databases can be compared.
+func dependencyOrder(oids []int64, dependencies map[int64][]int64, stmnts map[int64]string) []string {
+	ordered := make([]string, 0, len(oids))
+	visited := make(map[int64]bool)
+	var visit func(oid int64)
+	visit = func(oid int64) {
+		if visited[oid] {
+			return
+		}
+		visited[oid] = true
+		for _, dependency := range dependencies[oid] {
+			if _, ok := stmnts[dependency]; ok {
+				visit(dependency)
+			}
+		}
+		ordered = append(ordered, stmnts[oid])
+	}
+	for _, oid := range oids {
+		visit(oid)
+	}
+	return ordered
+}
+
+func (d *schemaDump) triggers() error {
+	stmnts := make([]string, 0)
+	err := d.query(stmntDumpTriggers, func(rows *sql.Rows) error {
+		var def string
+		err := rows.Scan(&def)
+		if err != nil {
+			return err
+		}
+		stmnts = append(stmnts, def+";")
+		return nil
+	}, pq.Array(append(append([]int64{}, d.tableOIDs...), d.viewOIDs...)))
+	if err != nil {
+		return NewError(err)
+	}
+	d.section("Triggers", stmnts)
+	return nil
+}
+
+func (d *schemaDump) comments() error {
+	stmnts := make([]string, 0)
+	err := d.query(stmntDumpComments, func(rows *sql.Rows) error {
+		var kind, name, comment string
+		err := rows.Scan(&kind, &name, &comment)
+		if err != nil {
+			return err
+		}
+		stmnts = append(stmnts, fmt.Sprintf("COMMENT ON %s %s IS %s;", kind, name, comment))
+		return nil
+	}, pq.Array(append(append([]int64{}, d.tableOIDs...), d.viewOIDs...)), pq.Array(d.schemaNames))
+	if err != nil {
+		return NewError(err)
+	}
+	d.section("Comments", stmnts)
+	return nil
+}
@@ -0,0 +1,201 @@
+package pgmngr
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDependencyOrder(t *testing.T) {
+	stmnts := map[int64]string{
+		1: "CREATE VIEW a",
+		2: "CREATE VIEW b",
+		3: "CREATE VIEW c",
+	}
+	// a depends on c, c on b, and b on a table that is not a view
+	dependencies := map[int64][]int64{
+		1: {3},
+		3: {2},
+		2: {99},
+	}
+	require.Equal(
+		t,
+		[]string{"CREATE VIEW b", "CREATE VIEW c", "CREATE VIEW a"},
+		dependencyOrder([]int64{1, 2, 3}, dependencies, stmnts),
+	)
+	require.Equal(
+		t,
+		[]string{"CREATE VIEW a", "CREATE VIEW b", "CREATE VIEW c"},
+		dependencyOrder([]int64{1, 2, 3}, nil, stmnts),
+	)
+}
+
+func TestSchemaAppliedVersions(t *testing.T) {
+	versions, err := schemaAppliedVersions([]byte(`CREATE TABLE public.people ();
+
+-- Applied migrations
+
+-- pgmngr-applied: 1600000001
+-- pgmngr-applied: 1600000002
+`))
+	require.NoError(t, err)
+	require.Equal(t, []int64{1600000001, 1600000002}, versions)
+
+	_, err = schemaAppliedVersions([]byte("-- pgmngr-applied: first\n"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "line 1: invalid applied migration")
+}
+
+func TestMigrator_DumpSchema(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "dump_schema")
+
+	writeTestMigration(t, tempDir, 1600000001, "schema", `
+CREATE TYPE public.mood AS ENUM ('sad', 'happy');
+CREATE TYPE public.place AS (street TEXT, city TEXT COLLATE "C");
+CREATE TYPE public.label AS (name TEXT, places public.place[]);
+CREATE TYPE public.floatrange AS RANGE (subtype = float8, subtype_diff = float8mi);
+CREATE FUNCTION public.time_diff(x TIME, y TIME) RETURNS FLOAT8
+  AS 'SELECT EXTRACT(EPOCH FROM (x - y))' LANGUAGE sql STRICT IMMUTABLE;
+CREATE TYPE public.timerange AS RANGE (subtype = TIME, subtype_diff = public.time_diff);
+CREATE TABLE public.people (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL DEFAULT 'unknown',
+  mood public.mood
+);
+CREATE TABLE public.pets (
+  id INT GENERATED ALWAYS AS IDENTITY,
+  person_id INT REFERENCES public.people (id),
+  CONSTRAINT pets_pk PRIMARY KEY (id)
+);
+CREATE INDEX pets_person_id_idx ON public.pets (person_id);
+CREATE VIEW public.happy_people AS SELECT id, name FROM public.people WHERE mood = 'happy';
+CREATE MATERIALIZED VIEW public.moods AS SELECT mood, count(*) FROM public.people GROUP BY mood;
+CREATE UNIQUE INDEX moods_mood_idx ON public.moods (mood);
+CREATE FUNCTION public.touch() RETURNS TRIGGER AS $$
+BEGIN
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER people_touch BEFORE UPDATE ON public.people FOR EACH ROW EXECUTE PROCEDURE public.touch();
+COMMENT ON TABLE public.people IS 'people''s table';
+COMMENT ON FUNCTION public.touch() IS 'touches';
+COMMENT ON TYPE public.mood IS 'moods';
+COMMENT ON INDEX public.pets_person_id_idx IS 'owners';
+COMMENT ON CONSTRAINT pets_pk ON public.pets IS 'key';
+COMMENT ON TRIGGER people_touch ON public.people IS 'touch';
+`, "SELECT 1;")
+
+	_, err := m.Up(ctx)
+	require.NoError(t, err)
+
+	dump, err := m.DumpSchema(ctx)
+	require.NoError(t, err)
+	for _, expected := range []string{
+		"SET LOCAL check_function_bodies = false;",
+		"CREATE TYPE public.mood AS ENUM ('sad', 'happy');",
+		"CREATE TYPE public.place AS (street text, city text COLLATE pg_catalog.\"C\");",
+		"CREATE TYPE public.label AS (name text, places public.place[]);",
+		"CREATE TYPE public.floatrange AS RANGE (SUBTYPE = double precision, SUBTYPE_DIFF = float8mi);",
+		"CREATE TYPE public.timerange AS RANGE (SUBTYPE = time without time zone, SUBTYPE_DIFF = public.time_diff);",
+		"CREATE SEQUENCE public.people_id_seq AS integer",
+		"    id integer DEFAULT nextval('public.people_id_seq'::regclass) NOT NULL,",
+		"    name text DEFAULT 'unknown'::text NOT NULL,",
+		"    id integer GENERATED ALWAYS AS IDENTITY NOT NULL,",
+		"ALTER SEQUENCE public.people_id_seq OWNED BY public.people.id;",
+		"ALTER TABLE ONLY public.pets ADD CONSTRAINT pets_pk PRIMARY KEY (id);",
+		"ALTER TABLE ONLY public.pets ADD CONSTRAINT pets_person_id_fkey FOREIGN KEY (person_id) REFERENCES public.people(id);",
+		"CREATE INDEX pets_person_id_idx ON public.pets USING btree (person_id);",
+		"CREATE VIEW public.happy_people AS",
+		"CREATE UNIQUE INDEX moods_mood_idx ON public.moods USING btree (mood);",
+		"CREATE OR REPLACE FUNCTION public.touch()",
+		"CREATE TRIGGER people_touch BEFORE UPDATE ON public.people FOR EACH ROW EXECUTE PROCEDURE public.touch();",
+		"COMMENT ON TABLE public.people IS 'people''s table';",
+		"COMMENT ON FUNCTION public.touch() IS 'touches';",
+		"COMMENT ON TYPE public.mood IS 'moods';",
+		"COMMENT ON INDEX public.pets_person_id_idx IS 'owners';",
+		"COMMENT ON CONSTRAINT pets_pk ON public.pets IS 'key';",
+		"COMMENT ON TRIGGER people_touch ON public.people IS 'touch';",
+	} {
+		require.Contains(t, dump, expected)
+	}
+	require.Less(t, strings.Index(dump, "CREATE TYPE public.place"), strings.Index(dump, "CREATE TYPE public.label"))
+	require.Less(
+		t,
+		strings.Index(dump, "CREATE OR REPLACE FUNCTION public.time_diff"),
+		strings.Index(dump, "CREATE TYPE public.timerange"),
+	)
+	require.Less(
+		t,
+		strings.Index(dump, "CREATE MATERIALIZED VIEW public.moods"),
+		strings.Index(dump, "CREATE UNIQUE INDEX moods_mood_idx"),
+	)
+	require.NotContains(t, dump, "schema_migrations")
+	require.NotContains(t, dump, "COMMENT ON SCHEMA public")
+	require.Contains(t, dump, "-- pgmngr-applied: 1600000001\n")
+
+	again, err := m.DumpSchema(ctx)
+	require.NoError(t, err)
+	require.Equal(t, dump, again)
+}
+
+func TestMigrator_LoadSchema(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "load_schema")
+	cfg := m.cfg
+
+	writeTestMigration(t, tempDir, 1600000001, "people", `
+CREATE TABLE public.people (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL
+);
+`, "DROP TABLE public.people;")
+
+	_, err := m.Up(ctx)
+	require.NoError(t, err)
+
+	dump, err := m.DumpSchema(ctx)
+	require.NoError(t, err)
+	schemaFile := filepath.Join(tempDir, "structure.sql")
+	err = ioutil.WriteFile(schemaFile, []byte(dump), 0644)
+	require.NoError(t, err)
+
+	// the migration is not run again, so the table must come from the schema
+	writeTestMigration(t, tempDir, 1600000001, "people", "SELECT 1 / 0;", "SELECT 1;")
+	writeTestMigration(t, tempDir, 1600000002, "pets", `
+CREATE TABLE public.pets (
+  id SERIAL PRIMARY KEY,
+  person_id INT REFERENCES public.people (id)
+);
+`, "DROP TABLE public.pets;")
+
+	loaded := *cfg
+	loaded.Connection.Migration.Database += "_loaded"
+	err = CreateDatabase(loaded)
+	require.NoError(t, err)
+	defer func(t *testing.T) {
+		err = DropDatabase(loaded)
+		require.NoError(t, err)
+	}(t)
+
+	lm, err := NewMigrator(&loaded)
+	require.NoError(t, err)
+	defer lm.Close()
+
+	results, err := lm.LoadSchema(ctx, schemaFile)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, int64(1600000001), results[0].Version)
+
+	_, err = lm.LoadSchema(ctx, schemaFile)
+	require.Error(t, err)
+
+	results, err = lm.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, int64(1600000002), results[0].Version)
+}
@@ -0,0 +1,99 @@
+package pgmngr
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// MigrationSource provides the migration files executed by a Migrator.
+type MigrationSource interface {
+	// Files returns the paths of all migration files known to the source.
+	Files() ([]string, error)
+	// ReadFile returns the contents of a path returned by Files.
+	ReadFile(name string) ([]byte, error)
+}
+
+// NewDirectorySource returns a MigrationSource reading the migration files
+// found in dir, and its subdirectories, on the local disk. It is the source
+// used when a Migrator is not given one.
+func NewDirectorySource(dir string) MigrationSource {
+	return directorySource(dir)
+}
+
+type directorySource string
+
+func (d directorySource) Files() ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(string(d), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return NewError(err)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	return files, nil
+}
+
+func (d directorySource) ReadFile(name string) ([]byte, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	return b, nil
+}
+
+// NewFSSource returns a MigrationSource reading the migration files found in
+// dir, and its subdirectories, of fsys. This allows migrations to be embedded
+// in a binary:
+//
+//	//go:embed migrations/*.sql
+//	var migrations embed.FS
+//
+//	m.Source = pgmngr.NewFSSource(migrations, "migrations")
+func NewFSSource(fsys fs.FS, dir string) MigrationSource {
+	return &fsSource{fsys: fsys, dir: dir}
+}
+
+type fsSource struct {
+	fsys fs.FS
+	dir  string
+}
+
+func (f *fsSource) Files() ([]string, error) {
+	files := make([]string, 0)
+	err := fs.WalkDir(f.fsys, path.Clean(f.dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return NewError(err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+func (f *fsSource) ReadFile(name string) ([]byte, error) {
+	b, err := fs.ReadFile(f.fsys, name)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	return b, nil
+}
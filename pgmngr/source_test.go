@@ -0,0 +1,61 @@
+package pgmngr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSSource(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1600000001_a.up.sql":               {Data: []byte("SELECT 1;")},
+		"migrations/1600000001_a.down.sql":             {Data: []byte("SELECT 2;")},
+		"migrations/nested/1600000002_b.no_txn.up.sql": {Data: []byte("SELECT 3;")},
+		"migrations/README.md":                         {Data: []byte("not a migration")},
+		"other/1600000003_c.up.sql":                    {Data: []byte("SELECT 4;")},
+	}
+	src := NewFSSource(fsys, "migrations")
+
+	mFiles, err := getMigrationFiles(Forward, src)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		migrationFiles{
+			1600000001: "migrations/1600000001_a.up.sql",
+			1600000002: "migrations/nested/1600000002_b.no_txn.up.sql",
+		},
+		mFiles,
+	)
+
+	mFiles, err = getMigrationFiles(Rollback, src)
+	require.NoError(t, err)
+	require.Equal(t, migrationFiles{1600000001: "migrations/1600000001_a.down.sql"}, mFiles)
+
+	b, err := src.ReadFile("migrations/1600000001_a.up.sql")
+	require.NoError(t, err)
+	require.Equal(t, "SELECT 1;", string(b))
+
+	_, err = NewFSSource(fsys, "missing").Files()
+	require.Error(t, err)
+}
+
+func TestDirectorySource(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "migrations_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	writeTestMigration(t, tempDir, 1600000001, "a", "SELECT 1;", "SELECT 2;")
+	src := NewDirectorySource(tempDir)
+
+	mFiles, err := getMigrationFiles(Forward, src)
+	require.NoError(t, err)
+	require.Equal(t, migrationFiles{1600000001: filepath.Join(tempDir, "1600000001_a.up.sql")}, mFiles)
+
+	b, err := src.ReadFile(mFiles[1600000001])
+	require.NoError(t, err)
+	require.Equal(t, "SELECT 1;", string(b))
+}
@@ -0,0 +1,503 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ParaServices/errgo"
+	"github.com/lib/pq"
+)
+
+// statement is a single SQL statement of a migration file.
+type statement struct {
+	SQL string
+	// Line is the line of the migration file the statement starts on.
+	Line int
+	// CopyData holds the rows following a COPY ... FROM stdin statement.
+	CopyData []string
+}
+
+var isCopyFromStdinRegex = regexp.MustCompile(`(?is)^COPY\s.*\sFROM\s+STDIN\b`)
+
+// copyOptionsRegex matches the options following FROM stdin, up to an
+// optional WHERE clause.
+var copyOptionsRegex = regexp.MustCompile(`(?is)\sFROM\s+STDIN\b(.*?)(?:\sWHERE\s.*)?$`)
+
+// copyOptionTokenRegex matches the words, string literals and punctuation of
+// COPY options.
+var copyOptionTokenRegex = regexp.MustCompile(`(?s)[Ee]?'(?:[^'\\]|''|\\.)*'|[^\s(),']+|[(),]`)
+
+func (s statement) isCopyFromStdin() bool {
+	return isCopyFromStdinRegex.MatchString(s.SQL)
+}
+
+// splitStatements splits the contents of a migration file into statements.
+// Semicolons inside string literals, quoted identifiers, dollar-quoted
+// bodies, comments and the BEGIN ATOMIC ... END body of a routine do not end
+// a statement, and the data rows of a COPY ... FROM stdin statement are
+// collected up to the terminating `\.`.
+func splitStatements(src string) ([]statement, error) {
+	statements := make([]statement, 0)
+	start := -1
+	// words holds the first words of the statement, and depth the nesting
+	// of the BEGIN ... END and CASE ... END blocks of a routine body
+	var words []string
+	depth := 0
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			continue
+		case c == '-' && strings.HasPrefix(src[i:], "--"):
+			j := strings.IndexByte(src[i:], '\n')
+			if j < 0 {
+				j = len(src) - i
+			}
+			i += j
+			continue
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			j, err := skipBlockComment(src, i)
+			if err != nil {
+				return nil, NewError(fmt.Errorf("line %v: %v", lineAt(src, i), err))
+			}
+			i = j - 1
+			continue
+		case c == ';' && depth == 0:
+			if start < 0 {
+				continue
+			}
+			stmnt := statement{
+				SQL:  strings.TrimSpace(src[start:i]),
+				Line: lineAt(src, start),
+			}
+			start = -1
+			words = nil
+			if stmnt.isCopyFromStdin() {
+				j, data, err := copyData(src, i+1)
+				if err != nil {
+					return nil, NewError(fmt.Errorf("line %v: %v", stmnt.Line, err))
+				}
+				stmnt.CopyData = data
+				i = j - 1
+				err = checkCopyOptions(stmnt.SQL)
+				if err != nil {
+					return nil, NewError(fmt.Errorf("line %v: %v", stmnt.Line, err))
+				}
+			}
+			statements = append(statements, stmnt)
+			continue
+		}
+
+		if start < 0 {
+			start = i
+		}
+
+		switch {
+		case c == '\'':
+			escapes := i > 0 && (src[i-1] == 'E' || src[i-1] == 'e') &&
+				(i < 2 || !isIdentifierByte(src[i-2]))
+			j, err := skipQuoted(src, i, '\'', escapes)
+			if err != nil {
+				return nil, NewError(fmt.Errorf("line %v: %v", lineAt(src, i), err))
+			}
+			i = j - 1
+		case c == '"':
+			j, err := skipQuoted(src, i, '"', false)
+			if err != nil {
+				return nil, NewError(fmt.Errorf("line %v: %v", lineAt(src, i), err))
+			}
+			i = j - 1
+		case c == '$' && (i == 0 || !isIdentifierByte(src[i-1])):
+			tag := dollarQuoteTag(src[i:])
+			if tag == "" {
+				continue
+			}
+			j := strings.Index(src[i+len(tag):], tag)
+			if j < 0 {
+				return nil, NewError(
+					fmt.Errorf("line %v: unterminated dollar-quoted string %s", lineAt(src, i), tag),
+				)
+			}
+			i += 2*len(tag) + j - 1
+		case isIdentifierByte(c):
+			j := i + 1
+			for j < len(src) && isIdentifierByte(src[j]) {
+				j++
+			}
+			word := strings.ToUpper(src[i:j])
+			if len(words) < 4 {
+				words = append(words, word)
+			}
+			switch {
+			case (word == "BEGIN" || word == "CASE") && isRoutine(words):
+				depth++
+			case word == "END" && depth > 0:
+				depth--
+			}
+			i = j - 1
+		}
+	}
+
+	if start >= 0 {
+		statements = append(statements, statement{
+			SQL:  strings.TrimSpace(src[start:]),
+			Line: lineAt(src, start),
+		})
+	}
+
+	return statements, nil
+}
+
+// isRoutine returns true when the first words of a statement create a
+// function or procedure.
+func isRoutine(words []string) bool {
+	if len(words) > 2 && words[1] == "OR" && words[2] == "REPLACE" {
+		words = append([]string{words[0]}, words[3:]...)
+	}
+	return len(words) > 1 && words[0] == "CREATE" &&
+		(words[1] == "FUNCTION" || words[1] == "PROCEDURE")
+}
+
+// lineAt returns the line number of the byte offset i of src.
+func lineAt(src string, i int) int {
+	return strings.Count(src[:i], "\n") + 1
+}
+
+// copyData returns the rows of COPY data following the statement ending at
+// i, and the index following the terminating `\.` line.
+func copyData(src string, i int) (int, []string, error) {
+	// the data starts on the line following the statement
+	j := strings.IndexByte(src[i:], '\n')
+	if j < 0 {
+		return 0, nil, fmt.Errorf("COPY data not found")
+	}
+	i += j + 1
+
+	rows := make([]string, 0)
+	for i < len(src) {
+		row := src[i:]
+		next := len(src)
+		if k := strings.IndexByte(row, '\n'); k >= 0 {
+			row = row[:k]
+			next = i + k + 1
+		}
+		i = next
+
+		row = strings.TrimSuffix(row, "\r")
+		if row == `\.` {
+			return i, rows, nil
+		}
+		rows = append(rows, row)
+	}
+
+	return 0, nil, fmt.Errorf("COPY data is not terminated by \\.")
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9') ||
+		c >= 0x80
+}
+
+// dollarQuoteTag returns the opening tag, e.g. `$$` or `$body$`, at the start
+// of s or an empty string if s does not start with one.
+func dollarQuoteTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c >= '0' && c <= '9':
+			if i == 1 {
+				// a positional parameter such as $1
+				return ""
+			}
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80:
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// skipQuoted returns the index following the closing quote of the literal
+// or identifier starting at i. Doubled quotes are part of the literal, as
+// are backslash escapes when escapes is set.
+func skipQuoted(src string, i int, quote byte, escapes bool) (int, error) {
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			if escapes {
+				j++
+			}
+		case quote:
+			if j+1 < len(src) && src[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1, nil
+		}
+	}
+	if quote == '"' {
+		return 0, fmt.Errorf("unterminated quoted identifier")
+	}
+	return 0, fmt.Errorf("unterminated quoted string")
+}
+
+// skipBlockComment returns the index following the end of the, possibly
+// nested, block comment starting at i.
+func skipBlockComment(src string, i int) (int, error) {
+	depth := 0
+	for j := i; j < len(src)-1; j++ {
+		switch {
+		case src[j] == '/' && src[j+1] == '*':
+			depth++
+			j++
+		case src[j] == '*' && src[j+1] == '/':
+			depth--
+			j++
+			if depth == 0 {
+				return j + 1, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated block comment")
+}
+
+// checkCopyOptions returns an error when the options of a COPY ... FROM stdin
+// statement are not the ones of the text format, with the default delimiter
+// and NULL string, as the data rows are sent in that format.
+func checkCopyOptions(stmnt string) error {
+	match := copyOptionsRegex.FindStringSubmatch(stmnt)
+	if match == nil {
+		return nil
+	}
+
+	tokens := make([]string, 0)
+	for _, token := range copyOptionTokenRegex.FindAllString(match[1], -1) {
+		switch strings.ToUpper(token) {
+		case "WITH", "AS", "(", ")", ",":
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		given := tokens[i]
+		option := strings.ToUpper(given)
+		value := ""
+		if i+1 < len(tokens) && isCopyOptionValue(option, tokens[i+1]) {
+			i++
+			given += " " + tokens[i]
+			value = copyOptionValue(tokens[i])
+		}
+
+		switch {
+		case option == "FORMAT" && strings.ToLower(value) == "text":
+		case option == "DELIMITER" && value == "\t":
+		case option == "NULL" && value == `\N`:
+		case option == "FREEZE":
+		default:
+			return fmt.Errorf(
+				"COPY option: %s is not supported, only the text format with the default delimiter and NULL string is",
+				given,
+			)
+		}
+	}
+	return nil
+}
+
+// isCopyOptionValue returns true when token is the value of the option
+// rather than the next option.
+func isCopyOptionValue(option, token string) bool {
+	if strings.HasSuffix(token, "'") {
+		return true
+	}
+	switch option {
+	case "FORMAT", "ENCODING", "DELIMITER", "NULL", "QUOTE", "ESCAPE", "DEFAULT":
+		return true
+	}
+	switch strings.ToUpper(token) {
+	case "TRUE", "FALSE", "ON", "OFF", "0", "1":
+		return true
+	}
+	return false
+}
+
+// copyOptionValue returns the value of a COPY option given as a word or a,
+// possibly escaped, string literal.
+func copyOptionValue(token string) string {
+	if !strings.HasSuffix(token, "'") {
+		return token
+	}
+	if token[0] == 'E' || token[0] == 'e' {
+		return unescapeCopyField(strings.Replace(token[2:len(token)-1], "''", "'", -1))
+	}
+	return strings.Replace(token[1:len(token)-1], "''", "'", -1)
+}
+
+// execStatement executes a single statement of a migration file. COPY ...
+// FROM stdin data is streamed using the COPY protocol, which requires a
+// transaction, so one is opened when tx is nil.
+func execStatement(ctx context.Context, conn *sql.Conn, tx *sql.Tx, stmnt statement) error {
+	if !stmnt.isCopyFromStdin() {
+		var exec execer
+		exec = conn
+		if tx != nil {
+			exec = tx
+		}
+		_, err := exec.ExecContext(ctx, stmnt.SQL)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	copyTx := tx
+	if copyTx == nil {
+		var err error
+		copyTx, err = conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := copyIn(ctx, copyTx, stmnt)
+	if tx != nil {
+		return err
+	}
+	if err != nil {
+		copyTx.Rollback()
+		return err
+	}
+	return copyTx.Commit()
+}
+
+func copyIn(ctx context.Context, tx *sql.Tx, stmnt statement) error {
+	prepared, err := tx.PrepareContext(ctx, stmnt.SQL)
+	if err != nil {
+		return err
+	}
+	defer prepared.Close()
+
+	for i := range stmnt.CopyData {
+		_, err = prepared.ExecContext(ctx, parseCopyRow(stmnt.CopyData[i])...)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = prepared.ExecContext(ctx)
+	return err
+}
+
+// parseCopyRow decodes a row of COPY text format data into its column
+// values, nil being NULL.
+func parseCopyRow(row string) []interface{} {
+	fields := strings.Split(row, "\t")
+	values := make([]interface{}, len(fields))
+	for i := range fields {
+		if fields[i] == `\N` {
+			values[i] = nil
+			continue
+		}
+		values[i] = unescapeCopyField(fields[i])
+	}
+	return values
+}
+
+func unescapeCopyField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] != '\\' || i == len(field)-1 {
+			builder.WriteByte(field[i])
+			continue
+		}
+		i++
+		switch c := field[i]; c {
+		case 'b':
+			builder.WriteByte('\b')
+		case 'f':
+			builder.WriteByte('\f')
+		case 'n':
+			builder.WriteByte('\n')
+		case 'r':
+			builder.WriteByte('\r')
+		case 't':
+			builder.WriteByte('\t')
+		case 'v':
+			builder.WriteByte('\v')
+		case 'x':
+			j := i + 1
+			for j < len(field) && j < i+3 && isHexDigit(field[j]) {
+				j++
+			}
+			if j == i+1 {
+				builder.WriteByte(c)
+				continue
+			}
+			v, _ := strconv.ParseUint(field[i+1:j], 16, 8)
+			builder.WriteByte(byte(v))
+			i = j - 1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(field) && j < i+3 && field[j] >= '0' && field[j] <= '7' {
+				j++
+			}
+			v, _ := strconv.ParseUint(field[i:j], 8, 8)
+			builder.WriteByte(byte(v))
+			i = j - 1
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// statementError locates the statement that failed in its migration file,
+// using the error position reported by Postgres when there is one.
+func statementError(filePath string, stmnt statement, err error) error {
+	line := stmnt.Line
+	pqErr, isPQErr := err.(*pq.Error)
+	if isPQErr {
+		position, convErr := strconv.Atoi(pqErr.Position)
+		runes := []rune(stmnt.SQL)
+		if convErr == nil && position > 0 && position <= len(runes) {
+			line += strings.Count(string(runes[:position-1]), "\n")
+		}
+	}
+
+	errx := errgo.New(fmt.Errorf("%s:%v: %v", filePath, line, err))
+	errx.Message = fmt.Sprintf("migration failed in file: %s on line: %v", filePath, line)
+	errx.Details.Add("file", filePath)
+	errx.Details.Add("line", strconv.Itoa(line))
+	errx.Details.Add("statement", stmnt.SQL)
+	if isPQErr {
+		errx.AddPQError(pqErr)
+		if pqErr.Position != "" {
+			errx.Details.Add("position", pqErr.Position)
+		}
+		if pqErr.Detail != "" {
+			errx.Details.Add("detail", pqErr.Detail)
+		}
+		if pqErr.Hint != "" {
+			errx.Details.Add("hint", pqErr.Hint)
+		}
+	}
+	return errx
+}
@@ -0,0 +1,233 @@
+package pgmngr
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStatements(t *testing.T) {
+	t.Run("simple statements", func(t *testing.T) {
+		stmnts, err := splitStatements("CREATE TABLE a();\n\nCREATE TABLE b();\nSELECT 1")
+		require.NoError(t, err)
+		require.Equal(
+			t,
+			[]statement{
+				{SQL: "CREATE TABLE a()", Line: 1},
+				{SQL: "CREATE TABLE b()", Line: 3},
+				{SQL: "SELECT 1", Line: 4},
+			},
+			stmnts,
+		)
+	})
+
+	t.Run("comments", func(t *testing.T) {
+		src := "-- SQL statement for migration goes here.\n" +
+			"/* a; /* nested; */ comment; */\n" +
+			"SELECT 1; -- trailing; comment\n" +
+			"SELECT /* inline; */ 2;\n" +
+			"-- only a comment;"
+		stmnts, err := splitStatements(src)
+		require.NoError(t, err)
+		require.Equal(
+			t,
+			[]statement{
+				{SQL: "SELECT 1", Line: 3},
+				{SQL: "SELECT /* inline; */ 2", Line: 4},
+			},
+			stmnts,
+		)
+	})
+
+	t.Run("placeholder only", func(t *testing.T) {
+		stmnts, err := splitStatements(string(upPlaceHolder))
+		require.NoError(t, err)
+		require.Empty(t, stmnts)
+	})
+
+	t.Run("string literals and identifiers", func(t *testing.T) {
+		src := "INSERT INTO \"a;b\" VALUES ('x;''y', E'\\';', $1);\nSELECT 'multi\nline;';"
+		stmnts, err := splitStatements(src)
+		require.NoError(t, err)
+		require.Equal(
+			t,
+			[]statement{
+				{SQL: "INSERT INTO \"a;b\" VALUES ('x;''y', E'\\';', $1)", Line: 1},
+				{SQL: "SELECT 'multi\nline;'", Line: 2},
+			},
+			stmnts,
+		)
+	})
+
+	t.Run("dollar quoting", func(t *testing.T) {
+		src := "CREATE FUNCTION f() RETURNS VOID AS $body$\n" +
+			"BEGIN\n" +
+			"  PERFORM $$a;b$$;\n" +
+			"END;\n" +
+			"$body$ LANGUAGE plpgsql;\n" +
+			"SELECT a$b FROM t;"
+		stmnts, err := splitStatements(src)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(stmnts))
+		require.Equal(t, 1, stmnts[0].Line)
+		require.Contains(t, stmnts[0].SQL, "END;\n$body$ LANGUAGE plpgsql")
+		require.Equal(t, statement{SQL: "SELECT a$b FROM t", Line: 6}, stmnts[1])
+	})
+
+	t.Run("begin atomic", func(t *testing.T) {
+		src := "CREATE OR REPLACE FUNCTION f(a int) RETURNS int LANGUAGE sql\n" +
+			"BEGIN ATOMIC\n" +
+			"  INSERT INTO t VALUES (a);\n" +
+			"  SELECT CASE WHEN a > 0 THEN a ELSE 0 END;\n" +
+			"END;\n" +
+			"BEGIN;\n" +
+			"SELECT f(1);\n" +
+			"END;"
+		stmnts, err := splitStatements(src)
+		require.NoError(t, err)
+		require.Equal(
+			t,
+			[]statement{
+				{
+					SQL: "CREATE OR REPLACE FUNCTION f(a int) RETURNS int LANGUAGE sql\n" +
+						"BEGIN ATOMIC\n" +
+						"  INSERT INTO t VALUES (a);\n" +
+						"  SELECT CASE WHEN a > 0 THEN a ELSE 0 END;\n" +
+						"END",
+					Line: 1,
+				},
+				{SQL: "BEGIN", Line: 6},
+				{SQL: "SELECT f(1)", Line: 7},
+				{SQL: "END", Line: 8},
+			},
+			stmnts,
+		)
+	})
+
+	t.Run("copy from stdin", func(t *testing.T) {
+		src := "COPY public.a (id, name) FROM stdin;\n" +
+			"1\tone;\n" +
+			"2\t\\N\n" +
+			"\\.\n" +
+			"SELECT 1;"
+		stmnts, err := splitStatements(src)
+		require.NoError(t, err)
+		require.Equal(
+			t,
+			[]statement{
+				{
+					SQL:      "COPY public.a (id, name) FROM stdin",
+					Line:     1,
+					CopyData: []string{"1\tone;", "2\t\\N"},
+				},
+				{SQL: "SELECT 1", Line: 5},
+			},
+			stmnts,
+		)
+	})
+
+	t.Run("unterminated", func(t *testing.T) {
+		for _, src := range []string{
+			"SELECT 'a;",
+			"SELECT \"a;",
+			"SELECT $$a;",
+			"SELECT 1; /* a;",
+			"COPY a FROM stdin;\n1\n",
+		} {
+			_, err := splitStatements(src)
+			require.Error(t, err, src)
+		}
+	})
+}
+
+func TestParseCopyRow(t *testing.T) {
+	require.Equal(
+		t,
+		[]interface{}{"1", nil, "a\tb\nc\\", "A", "A", ""},
+		parseCopyRow("1\t\\N\ta\\tb\\nc\\\\\t\\101\t\\x41\t"),
+	)
+}
+
+func TestCheckCopyOptions(t *testing.T) {
+	for _, stmnt := range []string{
+		"COPY public.a (id, name) FROM stdin",
+		"COPY public.a FROM STDIN WITH (FORMAT text)",
+		"COPY public.a FROM stdin (FORMAT 'text', DELIMITER E'\\t', NULL '\\N', FREEZE)",
+		"COPY public.a FROM stdin WITH DELIMITER AS E'\\t' NULL AS '\\N'",
+		"COPY public.a FROM stdin WITH (FREEZE true) WHERE id > 1",
+	} {
+		require.NoError(t, checkCopyOptions(stmnt), stmnt)
+	}
+
+	for stmnt, option := range map[string]string{
+		"COPY public.a FROM stdin WITH (FORMAT csv)":          "FORMAT csv",
+		"COPY public.a FROM stdin WITH CSV HEADER":            "CSV",
+		"COPY public.a FROM stdin BINARY":                     "BINARY",
+		"COPY public.a FROM stdin WITH (DELIMITER ',')":       "DELIMITER ','",
+		"COPY public.a FROM stdin WITH NULL AS ''":            "NULL ''",
+		"COPY public.a FROM stdin WITH (ENCODING 'LATIN1')":   "ENCODING 'LATIN1'",
+		"COPY public.a FROM stdin (FORMAT text, HEADER true)": "HEADER true",
+	} {
+		err := checkCopyOptions(stmnt)
+		require.Error(t, err, stmnt)
+		require.Contains(t, err.Error(), "COPY option: "+option+" is not supported", stmnt)
+	}
+
+	_, err := splitStatements("SELECT 1;\nCOPY public.a FROM stdin WITH (FORMAT csv);\n1,a\n\\.\n")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "line 2: COPY option: FORMAT csv is not supported")
+}
+
+func TestStatementError(t *testing.T) {
+	stmnt := statement{SQL: "SELECT 1,\n  2,\n  x", Line: 10}
+	err := statementError(
+		"1600000000_a.up.sql",
+		stmnt,
+		&pq.Error{Message: `column "x" does not exist`, Position: "17", Hint: "a hint"},
+	)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "1600000000_a.up.sql:12:")
+}
+
+func TestApplyMigrationStatements(t *testing.T) {
+	m, tempDir := testMigrator(t, "statements")
+	cfg := m.cfg
+
+	writeTestMigration(
+		t,
+		tempDir,
+		1600000001,
+		"copy",
+		"CREATE TABLE public.statements (id INT, name TEXT);\n"+
+			"COPY public.statements (id, name) FROM stdin;\n"+
+			"1\tone\n"+
+			"2\t\\N\n"+
+			"\\.\n",
+		"DROP TABLE public.statements;",
+	)
+	writeTestMigration(
+		t,
+		tempDir,
+		1600000002,
+		"indexes.no_txn",
+		"CREATE INDEX CONCURRENTLY statements_id_idx ON public.statements (id);\n"+
+			"CREATE INDEX CONCURRENTLY statements_name_idx ON public.statements (name);\n",
+		"DROP INDEX public.statements_id_idx;\nDROP INDEX public.statements_name_idx;",
+	)
+	writeTestMigration(
+		t,
+		tempDir,
+		1600000003,
+		"broken",
+		"SELECT 1;\n\nSELECT\n  missing_column\nFROM public.statements;\n",
+		"SELECT 1;",
+	)
+
+	err := ApplyMigration(Forward, cfg)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "1600000003_broken.up.sql:4:")
+
+	migrations := testAppliedVersions(t, cfg)
+	require.Equal(t, []int64{1600000001, 1600000002}, migrations)
+}
@@ -1,16 +1,43 @@
 package pgmngr
 
 var stmntInsertSchemaMigrationFn = `
-CREATE FUNCTION pg_temp.create_schema_migration(
+CREATE OR REPLACE FUNCTION pg_temp.create_schema_migration(
     _schema VARCHAR,
     _table_name VARCHAR,
-    _schema_migration_verson INT8
+    _schema_migration_verson INT8,
+    _checksum TEXT,
+    _file_name TEXT,
+    _name TEXT,
+    _duration_ms INT8,
+    _hostname TEXT,
+    _tool_version TEXT,
+    _in_transaction BOOL
 ) RETURNS VOID AS
 $$
 BEGIN
   EXECUTE format(
-    'INSERT INTO %I.%I(schema_migration_version)
-    VALUES (%s)', _schema, _table_name, _schema_migration_verson
+    'INSERT INTO %I.%I(
+      schema_migration_version,
+      checksum,
+      file_name,
+      name,
+      duration_ms,
+      applied_by,
+      hostname,
+      tool_version,
+      in_transaction
+    )
+    VALUES (%s, %L, %L, %L, %s, CURRENT_USER, %L, %L, %L)',
+    _schema,
+    _table_name,
+    _schema_migration_verson,
+    _checksum,
+    _file_name,
+    _name,
+    _duration_ms,
+    _hostname,
+    _tool_version,
+    _in_transaction
   );
 END;
 $$
@@ -18,37 +45,97 @@ language plpgsql;
 `
 var stmntInsertSchemaMigration = `
 SELECT * FROM pg_temp.create_schema_migration(
+  CAST(NULLIF($1, NULL) AS VARCHAR),
+  CAST(NULLIF($2, NULL) AS VARCHAR),
+  CAST(NULLIF($3, NULL) AS INT8),
+  CAST(NULLIF($4, NULL) AS TEXT),
+  CAST(NULLIF($5, NULL) AS TEXT),
+  CAST(NULLIF($6, NULL) AS TEXT),
+  CAST(NULLIF($7, NULL) AS INT8),
+  CAST(NULLIF($8, NULL) AS TEXT),
+  CAST(NULLIF($9, NULL) AS TEXT),
+  CAST(NULLIF($10, NULL) AS BOOL)
+)
+`
+
+var stmntUpdateSchemaMigrationChecksumFn = `
+CREATE OR REPLACE FUNCTION pg_temp.update_schema_migration_checksum(
+    _schema VARCHAR,
+    _table_name VARCHAR,
+    _schema_migration_verson INT8,
+    _checksum TEXT,
+    _file_name TEXT
+) RETURNS VOID AS
+$$
+BEGIN
+  EXECUTE format(
+    'UPDATE %I.%I
+    SET checksum = %L, file_name = %L
+    WHERE schema_migration_version = %s', _schema, _table_name, _checksum, _file_name, _schema_migration_verson
+  );
+END;
+$$
+language plpgsql;
+`
+
+var stmntUpdateSchemaMigrationChecksum = `
+SELECT * FROM pg_temp.update_schema_migration_checksum(
+  CAST(NULLIF($1, NULL) AS VARCHAR),
+  CAST(NULLIF($2, NULL) AS VARCHAR),
+  CAST(NULLIF($3, NULL) AS INT8),
+  CAST(NULLIF($4, NULL) AS TEXT),
+  CAST(NULLIF($5, NULL) AS TEXT)
+)
+`
+
+var stmntDeleteSchemaMigrationFn = `
+CREATE OR REPLACE FUNCTION pg_temp.delete_schema_migration(
+    _schema VARCHAR,
+    _table_name VARCHAR,
+    _schema_migration_verson INT8
+) RETURNS VOID AS
+$$
+BEGIN
+  EXECUTE format(
+    'DELETE FROM %I.%I
+    WHERE schema_migration_version = %s', _schema, _table_name, _schema_migration_verson
+  );
+END;
+$$
+language plpgsql;
+`
+
+var stmntDeleteSchemaMigration = `
+SELECT * FROM pg_temp.delete_schema_migration(
   CAST(NULLIF($1, NULL) AS VARCHAR),
   CAST(NULLIF($2, NULL) AS VARCHAR),
   CAST(NULLIF($3, NULL) AS INT8)
 )
 `
 
-var stmntAllSchemaMigrationsFn = `
-CREATE FUNCTION pg_temp.get_all_schema_migrations(
-  _schema_name TEXT,
-  _table_name TEXT
-) RETURNS TABLE (
-    schema_migration_version INT8
-) AS
+var stmntDeleteSchemaMigrationsBeforeFn = `
+CREATE OR REPLACE FUNCTION pg_temp.delete_schema_migrations_before(
+    _schema VARCHAR,
+    _table_name VARCHAR,
+    _schema_migration_verson INT8
+) RETURNS VOID AS
 $$
 BEGIN
-  RETURN QUERY
   EXECUTE format(
-   'SELECT t.schema_migration_version
-    FROM %I.%I t
-   ', _schema_name, _table_name
+    'DELETE FROM %I.%I
+    WHERE schema_migration_version < %s', _schema, _table_name, _schema_migration_verson
   );
 END;
 $$
 language plpgsql;
 `
 
-var stmntAllSchemaMigrations = `
-SELECT * FROM pg_temp.get_all_schema_migrations(
-  CAST(NULLIF($1, NULL) AS TEXT),
-  CAST(NULLIF($2, NULL) AS TEXT)
-);
+var stmntDeleteSchemaMigrationsBefore = `
+SELECT * FROM pg_temp.delete_schema_migrations_before(
+  CAST(NULLIF($1, NULL) AS VARCHAR),
+  CAST(NULLIF($2, NULL) AS VARCHAR),
+  CAST(NULLIF($3, NULL) AS INT8)
+)
 `
 
 var stmntSchemaMigrationTableExists = `
@@ -61,7 +148,7 @@ SELECT EXISTS (
 `
 
 var stmntCreateSchemaMigrationsTableFn = `
-CREATE FUNCTION pg_temp.create_schema_migrations_table(
+CREATE OR REPLACE FUNCTION pg_temp.create_schema_migrations_table(
   _schema TEXT,
   _database TEXT
 ) RETURNS INTEGER AS
@@ -78,6 +165,14 @@ BEGIN
        CREATE TABLE IF NOT EXISTS %I.%I (
          schema_migration_version INT8 NOT NULL,
          created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE ''UTC'') NOT NULL,
+         checksum TEXT,
+         file_name TEXT,
+         name TEXT,
+         duration_ms INT8,
+         applied_by TEXT,
+         hostname TEXT,
+         tool_version TEXT,
+         in_transaction BOOL,
          CONSTRAINT schema_migrations_pk PRIMARY KEY (schema_migration_version)
        )', _schema, _database
     );
@@ -96,12 +191,43 @@ FROM pg_temp.create_schema_migrations_table(
 );
 `
 
+var stmntUpgradeSchemaMigrationsTableFn = `
+CREATE OR REPLACE FUNCTION pg_temp.upgrade_schema_migrations_table(
+  _schema TEXT,
+  _table_name TEXT
+) RETURNS VOID AS
+$$
+BEGIN
+  EXECUTE format('
+     ALTER TABLE %I.%I
+       ADD COLUMN IF NOT EXISTS checksum TEXT,
+       ADD COLUMN IF NOT EXISTS file_name TEXT,
+       ADD COLUMN IF NOT EXISTS name TEXT,
+       ADD COLUMN IF NOT EXISTS duration_ms INT8,
+       ADD COLUMN IF NOT EXISTS applied_by TEXT,
+       ADD COLUMN IF NOT EXISTS hostname TEXT,
+       ADD COLUMN IF NOT EXISTS tool_version TEXT,
+       ADD COLUMN IF NOT EXISTS in_transaction BOOL
+     ', _schema, _table_name
+  );
+END;
+$$
+language plpgsql;
+`
+
+var stmntUpgradeSchemaMigrationsTable = `
+SELECT * FROM pg_temp.upgrade_schema_migrations_table(
+  CAST(NULLIF($1, NULL) AS TEXT),
+  CAST(NULLIF($2, NULL) AS TEXT)
+);
+`
+
 var stmntCreateExtensionDBLink = `
 CREATE EXTENSION IF NOT EXISTS dblink;
 `
 
 var stmntCreateDatabaseFn = `
-CREATE FUNCTION pg_temp.create_database(
+CREATE OR REPLACE FUNCTION pg_temp.create_database(
   _host TEXT,
   _port TEXT,
   _template_db TEXT,
@@ -147,7 +273,7 @@ SELECT * FROM pg_temp.create_database(
 `
 
 var stmntDropDatabaseFn = `
-CREATE FUNCTION pg_temp.drop_database(
+CREATE OR REPLACE FUNCTION pg_temp.drop_database(
   _host TEXT,
   _port TEXT,
   _template_db TEXT,
@@ -196,3 +322,613 @@ SELECT EXISTS(
   SELECT 1 FROM pg_catalog.pg_database WHERE lower(datname) = lower($1)
 );
 `
+
+var stmntAppliedSchemaMigrationsFn = `
+CREATE OR REPLACE FUNCTION pg_temp.get_applied_schema_migrations(
+  _schema_name TEXT,
+  _table_name TEXT
+) RETURNS TABLE (
+    schema_migration_version INT8,
+    created_at TIMESTAMP WITHOUT TIME ZONE,
+    checksum TEXT,
+    file_name TEXT,
+    name TEXT,
+    duration_ms INT8,
+    applied_by TEXT,
+    hostname TEXT,
+    tool_version TEXT,
+    in_transaction BOOL
+) AS
+$$
+BEGIN
+  RETURN QUERY
+  EXECUTE format(
+   'SELECT
+      t.schema_migration_version,
+      t.created_at,
+      to_jsonb(t) ->> ''checksum'',
+      to_jsonb(t) ->> ''file_name'',
+      to_jsonb(t) ->> ''name'',
+      CAST(to_jsonb(t) ->> ''duration_ms'' AS INT8),
+      to_jsonb(t) ->> ''applied_by'',
+      to_jsonb(t) ->> ''hostname'',
+      to_jsonb(t) ->> ''tool_version'',
+      CAST(to_jsonb(t) ->> ''in_transaction'' AS BOOL)
+    FROM %I.%I t
+    ORDER BY t.schema_migration_version
+   ', _schema_name, _table_name
+  );
+END;
+$$
+language plpgsql;
+`
+
+var stmntAppliedSchemaMigrations = `
+SELECT * FROM pg_temp.get_applied_schema_migrations(
+  CAST(NULLIF($1, NULL) AS TEXT),
+  CAST(NULLIF($2, NULL) AS TEXT)
+);
+`
+
+var stmntTryAdvisoryLock = `
+SELECT pg_try_advisory_lock(CAST($1 AS INT8));
+`
+
+var stmntAdvisoryUnlock = `
+SELECT pg_advisory_unlock(CAST($1 AS INT8));
+`
+
+var stmntAdvisoryLockHolder = `
+SELECT l.pid
+FROM pg_catalog.pg_locks l
+WHERE l.locktype = 'advisory'
+AND l.granted
+AND l.database = (SELECT oid FROM pg_catalog.pg_database WHERE datname = current_database())
+AND l.objsubid = 1
+AND ((CAST(l.classid AS INT8) << 32) | CAST(l.objid AS INT8)) = CAST($1 AS INT8)
+LIMIT 1;
+`
+
+var stmntSetConfig = `
+SELECT set_config(
+  CAST(NULLIF($1, NULL) AS TEXT),
+  CAST(NULLIF($2, NULL) AS TEXT),
+  CAST(NULLIF($3, NULL) AS BOOL)
+);
+`
+
+var stmntCreateRepeatableMigrationsTableFn = `
+CREATE OR REPLACE FUNCTION pg_temp.create_repeatable_migrations_table(
+  _schema TEXT,
+  _table_name TEXT
+) RETURNS VOID AS
+$$
+BEGIN
+  EXECUTE format('
+     CREATE TABLE IF NOT EXISTS %I.%I (
+       name TEXT NOT NULL,
+       checksum TEXT NOT NULL,
+       file_name TEXT,
+       applied_at TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE ''UTC'') NOT NULL,
+       duration_ms INT8,
+       applied_by TEXT,
+       hostname TEXT,
+       tool_version TEXT,
+       CONSTRAINT %I PRIMARY KEY (name)
+     )', _schema, _table_name, _table_name || '_pk'
+  );
+END;
+$$
+language plpgsql;
+`
+
+var stmntCreateRepeatableMigrationsTable = `
+SELECT * FROM pg_temp.create_repeatable_migrations_table(
+  CAST(NULLIF($1, NULL) AS TEXT),
+  CAST(NULLIF($2, NULL) AS TEXT)
+);
+`
+
+var stmntUpsertRepeatableMigrationFn = `
+CREATE OR REPLACE FUNCTION pg_temp.upsert_repeatable_migration(
+    _schema VARCHAR,
+    _table_name VARCHAR,
+    _name TEXT,
+    _checksum TEXT,
+    _file_name TEXT,
+    _duration_ms INT8,
+    _hostname TEXT,
+    _tool_version TEXT
+) RETURNS VOID AS
+$$
+BEGIN
+  EXECUTE format(
+    'INSERT INTO %I.%I(
+      name,
+      checksum,
+      file_name,
+      duration_ms,
+      applied_by,
+      hostname,
+      tool_version
+    )
+    VALUES (%L, %L, %L, %s, CURRENT_USER, %L, %L)
+    ON CONFLICT (name) DO UPDATE SET
+      checksum = EXCLUDED.checksum,
+      file_name = EXCLUDED.file_name,
+      applied_at = (NOW() AT TIME ZONE ''UTC''),
+      duration_ms = EXCLUDED.duration_ms,
+      applied_by = EXCLUDED.applied_by,
+      hostname = EXCLUDED.hostname,
+      tool_version = EXCLUDED.tool_version',
+    _schema,
+    _table_name,
+    _name,
+    _checksum,
+    _file_name,
+    _duration_ms,
+    _hostname,
+    _tool_version
+  );
+END;
+$$
+language plpgsql;
+`
+
+var stmntUpsertRepeatableMigration = `
+SELECT * FROM pg_temp.upsert_repeatable_migration(
+  CAST(NULLIF($1, NULL) AS VARCHAR),
+  CAST(NULLIF($2, NULL) AS VARCHAR),
+  CAST(NULLIF($3, NULL) AS TEXT),
+  CAST(NULLIF($4, NULL) AS TEXT),
+  CAST(NULLIF($5, NULL) AS TEXT),
+  CAST(NULLIF($6, NULL) AS INT8),
+  CAST(NULLIF($7, NULL) AS TEXT),
+  CAST(NULLIF($8, NULL) AS TEXT)
+)
+`
+
+var stmntAppliedRepeatableMigrationsFn = `
+CREATE OR REPLACE FUNCTION pg_temp.get_applied_repeatable_migrations(
+  _schema_name TEXT,
+  _table_name TEXT
+) RETURNS TABLE (
+    name TEXT,
+    checksum TEXT
+) AS
+$$
+BEGIN
+  RETURN QUERY
+  EXECUTE format(
+   'SELECT t.name, t.checksum
+    FROM %I.%I t
+    ORDER BY t.name
+   ', _schema_name, _table_name
+  );
+END;
+$$
+language plpgsql;
+`
+
+var stmntAppliedRepeatableMigrations = `
+SELECT * FROM pg_temp.get_applied_repeatable_migrations(
+  CAST(NULLIF($1, NULL) AS TEXT),
+  CAST(NULLIF($2, NULL) AS TEXT)
+);
+`
+
+var stmntDumpSchemas = `
+SELECT n.nspname
+FROM pg_catalog.pg_namespace n
+WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')
+AND n.nspname NOT LIKE 'pg\_%'
+AND NOT EXISTS (
+  SELECT 1 FROM pg_catalog.pg_depend d
+  WHERE d.objid = n.oid AND d.deptype = 'e'
+)
+ORDER BY n.nspname;
+`
+
+var stmntDumpExtensions = `
+SELECT quote_ident(e.extname), quote_ident(n.nspname)
+FROM pg_catalog.pg_extension e
+JOIN pg_catalog.pg_namespace n ON n.oid = e.extnamespace
+WHERE e.extname <> 'plpgsql'
+ORDER BY e.extname;
+`
+
+var stmntDumpEnums = `
+SELECT
+  format('%I.%I', n.nspname, t.typname),
+  string_agg(quote_literal(e.enumlabel), ', ' ORDER BY e.enumsortorder)
+FROM pg_catalog.pg_type t
+JOIN pg_catalog.pg_namespace n ON n.oid = t.typnamespace
+JOIN pg_catalog.pg_enum e ON e.enumtypid = t.oid
+WHERE n.nspname = ANY($1)
+AND NOT EXISTS (
+  SELECT 1 FROM pg_catalog.pg_depend d
+  WHERE d.objid = t.oid AND d.deptype = 'e'
+)
+GROUP BY n.nspname, t.typname
+ORDER BY 1;
+`
+
+var stmntDumpDomains = `
+SELECT
+  format('%I.%I', n.nspname, t.typname),
+  format_type(t.typbasetype, t.typtypmod),
+  t.typnotnull,
+  COALESCE(t.typdefault, ''),
+  COALESCE((
+    SELECT string_agg(format('CONSTRAINT %I %s', c.conname, pg_get_constraintdef(c.oid)), ' ' ORDER BY c.conname)
+    FROM pg_catalog.pg_constraint c
+    WHERE c.contypid = t.oid
+  ), '')
+FROM pg_catalog.pg_type t
+JOIN pg_catalog.pg_namespace n ON n.oid = t.typnamespace
+WHERE t.typtype = 'd'
+AND n.nspname = ANY($1)
+AND NOT EXISTS (
+  SELECT 1 FROM pg_catalog.pg_depend d
+  WHERE d.objid = t.oid AND d.deptype = 'e'
+)
+ORDER BY 1;
+`
+
+var stmntDumpRanges = `
+SELECT
+  format('%I.%I', n.nspname, t.typname),
+  format_type(r.rngsubtype, NULL),
+  COALESCE((
+    SELECT format('%I.%I', opn.nspname, opc.opcname)
+    FROM pg_catalog.pg_opclass opc
+    JOIN pg_catalog.pg_namespace opn ON opn.oid = opc.opcnamespace
+    WHERE opc.oid = r.rngsubopc AND NOT opc.opcdefault
+  ), ''),
+  COALESCE((
+    SELECT format('%I.%I', cn.nspname, co.collname)
+    FROM pg_catalog.pg_collation co
+    JOIN pg_catalog.pg_namespace cn ON cn.oid = co.collnamespace
+    WHERE co.oid = r.rngcollation AND r.rngcollation <> st.typcollation
+  ), ''),
+  CASE WHEN CAST(r.rngcanonical AS oid) <> 0 THEN CAST(r.rngcanonical AS TEXT) ELSE '' END,
+  CASE WHEN CAST(r.rngsubdiff AS oid) <> 0 THEN CAST(r.rngsubdiff AS TEXT) ELSE '' END,
+  NOT EXISTS (
+    SELECT 1 FROM pg_catalog.pg_proc p
+    WHERE p.oid IN (CAST(r.rngcanonical AS oid), CAST(r.rngsubdiff AS oid))
+    AND p.pronamespace <> CAST('pg_catalog' AS regnamespace)
+  )
+FROM pg_catalog.pg_range r
+JOIN pg_catalog.pg_type t ON t.oid = r.rngtypid
+JOIN pg_catalog.pg_namespace n ON n.oid = t.typnamespace
+JOIN pg_catalog.pg_type st ON st.oid = r.rngsubtype
+WHERE n.nspname = ANY($1)
+AND NOT EXISTS (
+  SELECT 1 FROM pg_catalog.pg_depend d
+  WHERE d.objid = t.oid AND d.deptype = 'e'
+)
+ORDER BY 1;
+`
+
+var stmntDumpCompositeTypes = `
+SELECT
+  t.oid,
+  format('%I.%I', n.nspname, t.typname),
+  COALESCE((
+    SELECT string_agg(
+      format('%I %s', a.attname, format_type(a.atttypid, a.atttypmod)) || COALESCE((
+        SELECT format(' COLLATE %I.%I', cn.nspname, co.collname)
+        FROM pg_catalog.pg_collation co
+        JOIN pg_catalog.pg_namespace cn ON cn.oid = co.collnamespace
+        WHERE co.oid = a.attcollation AND a.attcollation <> at.typcollation
+      ), ''),
+      ', ' ORDER BY a.attnum
+    )
+    FROM pg_catalog.pg_attribute a
+    JOIN pg_catalog.pg_type at ON at.oid = a.atttypid
+    WHERE a.attrelid = t.typrelid AND a.attnum > 0 AND NOT a.attisdropped
+  ), ''),
+  ARRAY(
+    SELECT CAST(COALESCE(NULLIF(at.typelem, 0), at.oid) AS INT8)
+    FROM pg_catalog.pg_attribute a
+    JOIN pg_catalog.pg_type at ON at.oid = a.atttypid
+    WHERE a.attrelid = t.typrelid AND a.attnum > 0 AND NOT a.attisdropped
+  )
+FROM pg_catalog.pg_type t
+JOIN pg_catalog.pg_namespace n ON n.oid = t.typnamespace
+JOIN pg_catalog.pg_class c ON c.oid = t.typrelid
+WHERE t.typtype = 'c'
+AND c.relkind = 'c'
+AND n.nspname = ANY($1)
+AND NOT EXISTS (
+  SELECT 1 FROM pg_catalog.pg_depend d
+  WHERE d.objid = t.oid AND d.deptype = 'e'
+)
+ORDER BY 2;
+`
+
+var stmntDumpSequences = `
+SELECT
+  format('%I.%I', s.schemaname, s.sequencename),
+  CAST(s.data_type AS TEXT),
+  s.start_value,
+  s.min_value,
+  s.max_value,
+  s.increment_by,
+  s.cache_size,
+  s.cycle
+FROM pg_catalog.pg_sequences s
+JOIN pg_catalog.pg_namespace n ON n.nspname = s.schemaname
+JOIN pg_catalog.pg_class c ON c.relnamespace = n.oid AND c.relname = s.sequencename
+WHERE s.schemaname = ANY($1)
+AND NOT EXISTS (
+  SELECT 1 FROM pg_catalog.pg_depend d
+  WHERE d.objid = c.oid AND d.deptype IN ('i', 'e')
+)
+ORDER BY 1;
+`
+
+var stmntDumpSequenceOwners = `
+SELECT
+  format('%I.%I', n.nspname, c.relname),
+  format('%I.%I.%I', tn.nspname, t.relname, a.attname)
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_catalog.pg_depend d ON d.objid = c.oid
+  AND d.classid = CAST('pg_catalog.pg_class' AS regclass)
+  AND d.refclassid = CAST('pg_catalog.pg_class' AS regclass)
+  AND d.deptype = 'a'
+JOIN pg_catalog.pg_class t ON t.oid = d.refobjid
+JOIN pg_catalog.pg_namespace tn ON tn.oid = t.relnamespace
+JOIN pg_catalog.pg_attribute a ON a.attrelid = t.oid AND a.attnum = d.refobjsubid
+WHERE c.relkind = 'S'
+AND n.nspname = ANY($1)
+AND NOT (tn.nspname || '.' || t.relname = ANY($2))
+ORDER BY 1;
+`
+
+var stmntDumpFunctions = `
+SELECT
+  pg_get_functiondef(p.oid),
+  format('%I.%I(%s)', n.nspname, p.proname, pg_get_function_identity_arguments(p.oid))
+FROM pg_catalog.pg_proc p
+JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+WHERE n.nspname = ANY($1)
+AND p.prokind IN ('f', 'p')
+AND NOT EXISTS (
+  SELECT 1 FROM pg_catalog.pg_depend d
+  WHERE d.objid = p.oid AND d.deptype = 'e'
+)
+ORDER BY n.nspname, p.proname, pg_get_function_identity_arguments(p.oid);
+`
+
+var stmntDumpTables = `
+SELECT
+  c.oid,
+  format('%I.%I', n.nspname, c.relname),
+  COALESCE(pg_get_partkeydef(c.oid), ''),
+  COALESCE((
+    SELECT format('%I.%I', pn.nspname, pc.relname)
+    FROM pg_catalog.pg_inherits i
+    JOIN pg_catalog.pg_class pc ON pc.oid = i.inhparent
+    JOIN pg_catalog.pg_namespace pn ON pn.oid = pc.relnamespace
+    WHERE i.inhrelid = c.oid
+  ), ''),
+  COALESCE(pg_get_expr(c.relpartbound, c.oid), '')
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p')
+AND n.nspname = ANY($1)
+AND NOT (n.nspname || '.' || c.relname = ANY($2))
+AND NOT EXISTS (
+  SELECT 1 FROM pg_catalog.pg_depend d
+  WHERE d.objid = c.oid AND d.deptype = 'e'
+)
+ORDER BY c.relispartition, 2;
+`
+
+var stmntDumpColumns = `
+SELECT
+  a.attrelid,
+  quote_ident(a.attname),
+  format_type(a.atttypid, a.atttypmod),
+  a.attnotnull,
+  COALESCE(pg_get_expr(ad.adbin, ad.adrelid), ''),
+  CAST(a.attidentity AS TEXT),
+  COALESCE(to_jsonb(a) ->> 'attgenerated', ''),
+  COALESCE((
+    SELECT format('%I.%I', cn.nspname, co.collname)
+    FROM pg_catalog.pg_collation co
+    JOIN pg_catalog.pg_namespace cn ON cn.oid = co.collnamespace
+    WHERE co.oid = a.attcollation AND a.attcollation <> t.typcollation
+  ), ''),
+  a.attislocal
+FROM pg_catalog.pg_attribute a
+JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+LEFT JOIN pg_catalog.pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+WHERE a.attrelid = ANY(CAST($1 AS oid[]))
+AND a.attnum > 0
+AND NOT a.attisdropped
+ORDER BY a.attrelid, a.attnum;
+`
+
+var stmntDumpConstraints = `
+SELECT
+  format('%I.%I', n.nspname, c.relname),
+  quote_ident(co.conname),
+  pg_get_constraintdef(co.oid)
+FROM pg_catalog.pg_constraint co
+JOIN pg_catalog.pg_class c ON c.oid = co.conrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE co.conrelid = ANY(CAST($1 AS oid[]))
+AND co.contype IN ('p', 'u', 'c', 'x', 'f')
+AND co.conislocal
+AND co.conparentid = 0
+ORDER BY co.contype = 'f', 1, 2;
+`
+
+var stmntDumpIndexes = `
+SELECT pg_get_indexdef(i.indexrelid)
+FROM pg_catalog.pg_index i
+WHERE i.indrelid = ANY(CAST($1 AS oid[]))
+AND NOT EXISTS (
+  SELECT 1 FROM pg_catalog.pg_constraint co
+  WHERE co.conindid = i.indexrelid AND co.contype IN ('p', 'u', 'x')
+)
+AND NOT EXISTS (
+  SELECT 1 FROM pg_catalog.pg_inherits inh
+  WHERE inh.inhrelid = i.indexrelid
+)
+ORDER BY 1;
+`
+
+var stmntDumpViews = `
+SELECT
+  c.oid,
+  format('%I.%I', n.nspname, c.relname),
+  c.relkind = 'm',
+  pg_get_viewdef(c.oid)
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('v', 'm')
+AND n.nspname = ANY($1)
+AND NOT EXISTS (
+  SELECT 1 FROM pg_catalog.pg_depend d
+  WHERE d.objid = c.oid AND d.deptype = 'e'
+)
+ORDER BY 2;
+`
+
+var stmntDumpViewDependencies = `
+SELECT DISTINCT v.oid, d.refobjid
+FROM pg_catalog.pg_depend d
+JOIN pg_catalog.pg_rewrite r ON r.oid = d.objid
+JOIN pg_catalog.pg_class v ON v.oid = r.ev_class
+WHERE d.classid = CAST('pg_catalog.pg_rewrite' AS regclass)
+AND d.refclassid = CAST('pg_catalog.pg_class' AS regclass)
+AND d.refobjid <> v.oid
+AND v.oid = ANY(CAST($1 AS oid[]));
+`
+
+var stmntDumpViewFunctions = `
+SELECT DISTINCT
+  v.oid,
+  format('%I.%I(%s)', n.nspname, p.proname, pg_get_function_identity_arguments(p.oid))
+FROM pg_catalog.pg_depend d
+JOIN pg_catalog.pg_rewrite r ON r.oid = d.objid
+JOIN pg_catalog.pg_class v ON v.oid = r.ev_class
+JOIN pg_catalog.pg_proc p ON p.oid = d.refobjid
+JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+WHERE d.classid = CAST('pg_catalog.pg_rewrite' AS regclass)
+AND d.refclassid = CAST('pg_catalog.pg_proc' AS regclass)
+AND n.nspname <> 'pg_catalog'
+AND v.oid = ANY(CAST($1 AS oid[]));
+`
+
+var stmntDumpTriggers = `
+SELECT pg_get_triggerdef(t.oid)
+FROM pg_catalog.pg_trigger t
+JOIN pg_catalog.pg_class c ON c.oid = t.tgrelid
+WHERE t.tgrelid = ANY(CAST($1 AS oid[]))
+AND NOT t.tgisinternal
+AND COALESCE(CAST(to_jsonb(t) ->> 'tgparentid' AS oid), 0) = 0
+ORDER BY 1;
+`
+
+var stmntDumpComments = `
+SELECT kind, name, quote_literal(description)
+FROM (
+  SELECT
+    CASE
+      WHEN d.objsubid > 0 THEN 'COLUMN'
+      WHEN c.relkind = 'v' THEN 'VIEW'
+      WHEN c.relkind = 'm' THEN 'MATERIALIZED VIEW'
+      ELSE 'TABLE'
+    END AS kind,
+    format('%I.%I', n.nspname, c.relname) ||
+      COALESCE('.' || quote_ident(a.attname), '') AS name,
+    d.description
+  FROM pg_catalog.pg_description d
+  JOIN pg_catalog.pg_class c ON c.oid = d.objoid
+  JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+  LEFT JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum = d.objsubid AND d.objsubid > 0
+  WHERE d.classoid = CAST('pg_catalog.pg_class' AS regclass)
+  AND d.objoid = ANY(CAST($1 AS oid[]))
+  UNION ALL
+  SELECT 'INDEX', format('%I.%I', n.nspname, c.relname), d.description
+  FROM pg_catalog.pg_description d
+  JOIN pg_catalog.pg_index i ON i.indexrelid = d.objoid
+  JOIN pg_catalog.pg_class c ON c.oid = i.indexrelid
+  JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+  WHERE d.classoid = CAST('pg_catalog.pg_class' AS regclass)
+  AND i.indrelid = ANY(CAST($1 AS oid[]))
+  UNION ALL
+  SELECT 'CONSTRAINT', format('%I ON %I.%I', co.conname, n.nspname, c.relname), d.description
+  FROM pg_catalog.pg_description d
+  JOIN pg_catalog.pg_constraint co ON co.oid = d.objoid
+  JOIN pg_catalog.pg_class c ON c.oid = co.conrelid
+  JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+  WHERE d.classoid = CAST('pg_catalog.pg_constraint' AS regclass)
+  AND co.conrelid = ANY(CAST($1 AS oid[]))
+  UNION ALL
+  SELECT 'TRIGGER', format('%I ON %I.%I', t.tgname, n.nspname, c.relname), d.description
+  FROM pg_catalog.pg_description d
+  JOIN pg_catalog.pg_trigger t ON t.oid = d.objoid
+  JOIN pg_catalog.pg_class c ON c.oid = t.tgrelid
+  JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+  WHERE d.classoid = CAST('pg_catalog.pg_trigger' AS regclass)
+  AND t.tgrelid = ANY(CAST($1 AS oid[]))
+  AND NOT t.tgisinternal
+  UNION ALL
+  SELECT
+    CASE WHEN p.prokind = 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END,
+    format('%I.%I(%s)', n.nspname, p.proname, pg_get_function_identity_arguments(p.oid)),
+    d.description
+  FROM pg_catalog.pg_description d
+  JOIN pg_catalog.pg_proc p ON p.oid = d.objoid
+  JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+  WHERE d.classoid = CAST('pg_catalog.pg_proc' AS regclass)
+  AND n.nspname = ANY($2)
+  AND p.prokind IN ('f', 'p')
+  AND NOT EXISTS (
+    SELECT 1 FROM pg_catalog.pg_depend dep
+    WHERE dep.objid = p.oid AND dep.deptype = 'e'
+  )
+  UNION ALL
+  SELECT
+    CASE WHEN t.typtype = 'd' THEN 'DOMAIN' ELSE 'TYPE' END,
+    format('%I.%I', n.nspname, t.typname),
+    d.description
+  FROM pg_catalog.pg_description d
+  JOIN pg_catalog.pg_type t ON t.oid = d.objoid
+  JOIN pg_catalog.pg_namespace n ON n.oid = t.typnamespace
+  LEFT JOIN pg_catalog.pg_class c ON c.oid = t.typrelid
+  WHERE d.classoid = CAST('pg_catalog.pg_type' AS regclass)
+  AND n.nspname = ANY($2)
+  AND (t.typtype IN ('e', 'd', 'r') OR (t.typtype = 'c' AND c.relkind = 'c'))
+  AND NOT EXISTS (
+    SELECT 1 FROM pg_catalog.pg_depend dep
+    WHERE dep.objid = t.oid AND dep.deptype = 'e'
+  )
+  UNION ALL
+  SELECT 'SCHEMA', quote_ident(n.nspname), d.description
+  FROM pg_catalog.pg_description d
+  JOIN pg_catalog.pg_namespace n ON n.oid = d.objoid
+  WHERE d.classoid = CAST('pg_catalog.pg_namespace' AS regclass)
+  AND n.nspname = ANY($2)
+  -- the comment every database gives the public schema
+  AND NOT (n.nspname = 'public' AND d.description = 'standard public schema')
+  UNION ALL
+  SELECT 'EXTENSION', quote_ident(e.extname), d.description
+  FROM pg_catalog.pg_description d
+  JOIN pg_catalog.pg_extension e ON e.oid = d.objoid
+  WHERE d.classoid = CAST('pg_catalog.pg_extension' AS regclass)
+  AND e.extname <> 'plpgsql'
+  -- CREATE EXTENSION sets the comment of the control file
+  AND d.description IS DISTINCT FROM (
+    SELECT ae.comment FROM pg_catalog.pg_available_extensions ae
+    WHERE ae.name = e.extname
+  )
+) comments
+ORDER BY 2, 1;
+`
@@ -0,0 +1,355 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SquashResult describes the baseline written by Squash.
+type SquashResult struct {
+	// File is the baseline migration file.
+	File string `json:"file"`
+	// Archive is the directory the squashed migration files were moved to.
+	Archive string `json:"archive"`
+	// Versions are the squashed versions.
+	Versions []int64 `json:"versions"`
+}
+
+var squashedDownFile = []byte(`-- Squashed baselines cannot be rolled back.
+DO $$
+BEGIN
+  RAISE EXCEPTION 'squashed baseline migrations cannot be rolled back';
+END;
+$$;
+`)
+
+// squashArchive returns the default directory squashed migration files are
+// moved to, next to the migration directory so they are no longer found.
+func squashArchive(dir string, upTo int64) string {
+	dir = filepath.Clean(dir)
+	return filepath.Join(filepath.Dir(dir), filepath.Base(dir)+"_archive", strconv.FormatInt(upTo, 10))
+}
+
+// Squash replaces every migration up to and including version upTo with a
+// single baseline file. The migrations are applied to a scratch database
+// whose schema is dumped into the baseline and, once the baseline is written
+// and recorded, the original files are moved to archive, the default archive
+// being used when empty. The baseline takes the
+// version upTo, so databases that already applied it run nothing: the next
+// time they are migrated, their records of the squashed versions are
+// replaced by the record of the baseline.
+func (m *Migrator) Squash(ctx context.Context, upTo int64, archive string) (*SquashResult, error) {
+	if m.Source != nil {
+		return nil, NewError(fmt.Errorf("migrations can only be squashed in the migration directory"))
+	}
+	dir := m.cfg.Migration.Directory
+	if archive == "" {
+		archive = squashArchive(dir, upTo)
+	}
+
+	mFiles, err := m.migrationFiles(Forward)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	if _, ok := mFiles[upTo]; !ok {
+		return nil, NewError(fmt.Errorf("migration file for version: %v not found", upTo))
+	}
+
+	versions := make([]int64, 0)
+	for version, filePath := range mFiles {
+		if version > upTo {
+			continue
+		}
+		if isGoMigrationFile(filePath) {
+			return nil, NewError(
+				fmt.Errorf("version: %v is a Go migration, it must be unregistered before squashing", version),
+			)
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(
+		versions,
+		func(i, j int) bool {
+			return versions[i] < versions[j]
+		},
+	)
+
+	// the files are listed before the baseline, which takes the version upTo
+	files, err := squashedFiles(m.source(), upTo)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	dump, err := m.squashedSchema(ctx, upTo)
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	prefix := filepath.Join(dir, fmt.Sprint(upTo, "_squashed"))
+	header := fmt.Sprintf(
+		"-- %s squash\n-- Baseline of the migrations up to version: %v, squashed on %s.\n\n",
+		directivePrefix,
+		upTo,
+		time.Now().UTC().Format(time.RFC3339),
+	)
+	removeBaseline := func() {
+		os.Remove(prefix + ".up.sql")
+		os.Remove(prefix + ".down.sql")
+	}
+	err = ioutil.WriteFile(prefix+".up.sql", []byte(header+dump), 0644)
+	if err != nil {
+		removeBaseline()
+		return nil, NewError(err)
+	}
+	err = ioutil.WriteFile(prefix+".down.sql", squashedDownFile, 0644)
+	if err != nil {
+		removeBaseline()
+		return nil, NewError(err)
+	}
+
+	// record the squash right away when the database is migrated already
+	_, err = m.migrate(ctx, func(conn *sql.Conn) ([]MigrationResult, error) {
+		applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		if len(applied) == 0 {
+			return nil, nil
+		}
+
+		_, err = m.recordSquashes(ctx, conn, migrationFiles{upTo: prefix + ".up.sql"}, applied)
+		return nil, err
+	})
+	if err != nil {
+		removeBaseline()
+		return nil, NewError(err)
+	}
+
+	err = archiveMigrations(files, archive)
+	if err != nil {
+		return nil, NewError(fmt.Errorf(
+			"baseline: %s is recorded but the squashed migrations were not archived, move them to: %s: %v",
+			prefix+".up.sql",
+			archive,
+			err,
+		))
+	}
+
+	return &SquashResult{File: prefix + ".up.sql", Archive: archive, Versions: versions}, nil
+}
+
+// squashedSchema migrates a scratch database up to version upTo and returns
+// its schema, without the tables tracking the migrations. An existing database
+// with the name of the scratch database is an error, as is failing to drop the
+// scratch database afterwards.
+func (m *Migrator) squashedSchema(ctx context.Context, upTo int64) (dump string, err error) {
+	scratch := *m.cfg
+	scratch.Connection.Migration.Database = fmt.Sprintf("%s_squash_%v", m.cfg.Connection.Migration.Database, upTo)
+
+	exists, err := dbExists(scratch)
+	if err != nil {
+		return "", NewError(err)
+	}
+	if exists {
+		// it might be a database of its own, it is never dropped
+		return "", NewError(fmt.Errorf(
+			"scratch database: %s already exists, drop it before squashing",
+			scratch.Connection.Migration.Database,
+		))
+	}
+
+	err = CreateDatabase(scratch)
+	if err != nil {
+		return "", NewError(err)
+	}
+	defer func() {
+		dropErr := DropDatabase(scratch)
+		if dropErr != nil && err == nil {
+			err = NewError(fmt.Errorf(
+				"failed to drop scratch database: %s, drop it before squashing again: %v",
+				scratch.Connection.Migration.Database,
+				dropErr,
+			))
+		}
+	}()
+
+	sm, err := NewMigrator(&scratch)
+	if err != nil {
+		return "", NewError(err)
+	}
+	defer sm.Close()
+	sm.Environment = m.Environment
+
+	_, err = sm.To(ctx, upTo)
+	if err != nil {
+		return "", NewError(err)
+	}
+
+	// the versions are left out of the baseline, unlike with DumpSchema
+	err = sm.session(ctx, false, func(conn *sql.Conn) error {
+		dump, err = dumpSchema(ctx, conn, trackingTables(&scratch))
+		return err
+	})
+	if err != nil {
+		return "", NewError(err)
+	}
+
+	return dump, nil
+}
+
+// squashedFiles returns the up and down files of every migration up to and
+// including version upTo.
+func squashedFiles(src MigrationSource, upTo int64) ([]string, error) {
+	files := make([]string, 0)
+	for _, mType := range []MigrationType{Forward, Rollback} {
+		mFiles, err := getMigrationFiles(mType, src)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		for _, version := range mFiles.Versions() {
+			if version <= upTo {
+				files = append(files, mFiles[version])
+			}
+		}
+	}
+	return files, nil
+}
+
+// archiveMigrations moves the files to the archive directory. The files moved
+// already are moved back when one of them cannot be.
+func archiveMigrations(files []string, archive string) error {
+	err := os.MkdirAll(archive, 0755)
+	if err != nil {
+		return NewError(err)
+	}
+
+	for i, filePath := range files {
+		err = os.Rename(filePath, filepath.Join(archive, filepath.Base(filePath)))
+		if err != nil {
+			for _, archived := range files[:i] {
+				os.Rename(filepath.Join(archive, filepath.Base(archived)), archived)
+			}
+			return NewError(err)
+		}
+	}
+
+	return nil
+}
+
+// recordSquashes replaces the records of the migrations squashed into a
+// baseline by the record of the baseline, for databases that applied the
+// migrations before they were squashed. It refuses to continue when the
+// database is older than a pending baseline, as the migrations it still needs
+// were archived. The applied migrations are returned as recorded afterwards.
+func (m *Migrator) recordSquashes(ctx context.Context, conn *sql.Conn, mFiles migrationFiles, applied []MigrationRecord) ([]MigrationRecord, error) {
+	if len(applied) == 0 {
+		return applied, nil
+	}
+	records := make(map[int64]MigrationRecord)
+	for i := range applied {
+		records[applied[i].Version] = applied[i]
+	}
+	oldest := applied[0].Version
+	for i := range applied {
+		if applied[i].Version < oldest {
+			oldest = applied[i].Version
+		}
+	}
+
+	changed := false
+	for _, version := range mFiles.Versions() {
+		filePath := mFiles[version]
+		if isGoMigrationFile(filePath) {
+			continue
+		}
+		pm, err := newPlannedMigration(m.source(), version, filePath)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		if !pm.Squash {
+			continue
+		}
+
+		record, ok := records[version]
+		if !ok {
+			if oldest < version {
+				return nil, NewError(
+					fmt.Errorf(
+						"version: %v is applied but older than the squashed baseline: %s, apply the archived migrations up to version: %v first",
+						oldest,
+						filePath,
+						version,
+					),
+				)
+			}
+			continue
+		}
+		if record.Checksum == pm.Checksum {
+			continue
+		}
+
+		err = m.recordSquash(ctx, conn, pm)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		changed = true
+	}
+
+	if !changed {
+		return applied, nil
+	}
+	return getAppliedMigrations(ctx, conn, m.cfg)
+}
+
+func (m *Migrator) recordSquash(ctx context.Context, conn *sql.Conn, pm PlannedMigration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return NewError(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		stmntDeleteSchemaMigrationsBefore,
+		m.cfg.Migration.Table.Schema,
+		m.cfg.Migration.Table.Name,
+		pm.Version,
+	)
+	if err != nil {
+		tx.Rollback()
+		return NewError(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		stmntUpdateSchemaMigrationChecksum,
+		m.cfg.Migration.Table.Schema,
+		m.cfg.Migration.Table.Name,
+		pm.Version,
+		pm.Checksum,
+		filepath.Base(pm.File),
+	)
+	if err != nil {
+		tx.Rollback()
+		return NewError(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return NewError(err)
+	}
+
+	m.notify(MigrationEvent{
+		Kind:    EventSquashRecorded,
+		Version: pm.Version,
+		File:    pm.File,
+		Message: fmt.Sprintf("Recorded the migrations up to version: %v as squashed", pm.Version),
+	})
+	return nil
+}
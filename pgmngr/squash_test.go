@@ -0,0 +1,100 @@
+package pgmngr
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSquashArchive(t *testing.T) {
+	require.Equal(
+		t,
+		filepath.Join("db", "migrations_archive", "1600000000"),
+		squashArchive(filepath.Join("db", "migrations")+"/", 1600000000),
+	)
+}
+
+func TestArchiveMigrations(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "migrations_")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	dir := filepath.Join(tempDir, "migrations")
+	err = os.Mkdir(dir, 0755)
+	require.NoError(t, err)
+	writeTestMigration(t, dir, 1600000001, "a", "SELECT 1;", "SELECT 1;")
+	writeTestMigration(t, dir, 1600000002, "b", "SELECT 2;", "SELECT 2;")
+	writeTestMigration(t, dir, 1600000003, "c", "SELECT 3;", "SELECT 3;")
+
+	files, err := squashedFiles(NewDirectorySource(dir), 1600000002)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(files))
+
+	archive := squashArchive(dir, 1600000002)
+	err = archiveMigrations(files, archive)
+	require.NoError(t, err)
+
+	archived, err := NewDirectorySource(archive).Files()
+	require.NoError(t, err)
+	require.Equal(t, 4, len(archived))
+
+	remaining, err := NewDirectorySource(dir).Files()
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		[]string{
+			filepath.Join(dir, "1600000003_c.down.sql"),
+			filepath.Join(dir, "1600000003_c.up.sql"),
+		},
+		remaining,
+	)
+
+	// the files are moved back when one of them cannot be archived
+	err = archiveMigrations(append(remaining, filepath.Join(dir, "missing.up.sql")), archive)
+	require.Error(t, err)
+	restored, err := NewDirectorySource(dir).Files()
+	require.NoError(t, err)
+	require.Equal(t, remaining, restored)
+}
+
+func TestMigrator_Squash(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "squash")
+	cfg := m.cfg
+
+	// the archive is created next to the migration directory
+	dir := filepath.Join(tempDir, "migrations")
+	err := os.Mkdir(dir, 0755)
+	require.NoError(t, err)
+	cfg.Migration.Directory = dir
+
+	writeTestMigration(t, dir, 1600000001, "a", "CREATE TABLE public.squash_a(id INT PRIMARY KEY);", "DROP TABLE public.squash_a;")
+	writeTestMigration(t, dir, 1600000002, "b", "ALTER TABLE public.squash_a ADD COLUMN name TEXT;", "ALTER TABLE public.squash_a DROP COLUMN name;")
+	writeTestMigration(t, dir, 1600000003, "c", "CREATE TABLE public.squash_c();", "DROP TABLE public.squash_c;")
+
+	_, err = m.To(ctx, 1600000002)
+	require.NoError(t, err)
+
+	result, err := m.Squash(ctx, 1600000002, "")
+	require.NoError(t, err)
+	require.Equal(t, []int64{1600000001, 1600000002}, result.Versions)
+	require.Equal(t, filepath.Join(dir, "1600000002_squashed.up.sql"), result.File)
+
+	b, err := ioutil.ReadFile(result.File)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "-- pgmngr: squash")
+	require.Contains(t, string(b), "CREATE TABLE public.squash_a")
+	require.NotContains(t, string(b), "schema_migrations")
+
+	applied := testAppliedVersions(t, cfg)
+	require.Equal(t, []int64{1600000002}, applied)
+
+	results, err := m.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(results))
+	require.Equal(t, int64(1600000003), results[0].Version)
+}
@@ -0,0 +1,216 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MigrationState describes whether a migration has been applied.
+type MigrationState string
+
+const (
+	// StateApplied the migration has been applied and its file exists
+	StateApplied MigrationState = "applied"
+	// StatePending the migration file exists but has not been applied
+	StatePending MigrationState = "pending"
+	// StateFileMissing the migration has been applied but its file is gone
+	StateFileMissing MigrationState = "applied but file missing"
+)
+
+// MigrationStatus is the state of a single migration version.
+type MigrationStatus struct {
+	Version   int64          `json:"version"`
+	Name      string         `json:"name,omitempty"`
+	File      string         `json:"file,omitempty"`
+	AppliedAt *time.Time     `json:"applied_at,omitempty"`
+	State     MigrationState `json:"state"`
+}
+
+// MigrationStatuses is a list of migration statuses ordered by version.
+type MigrationStatuses []MigrationStatus
+
+// Pending returns the statuses of the migrations that have not been applied.
+func (m MigrationStatuses) Pending() MigrationStatuses {
+	pending := make(MigrationStatuses, 0)
+	for i := range m {
+		if m[i].State == StatePending {
+			pending = append(pending, m[i])
+		}
+	}
+	return pending
+}
+
+// MigrationRecord is a row of the schema migrations table. The fields other
+// than Version and AppliedAt are empty for migrations applied before they
+// were recorded.
+type MigrationRecord struct {
+	Version     int64     `json:"version"`
+	Name        string    `json:"name,omitempty"`
+	FileName    string    `json:"file_name,omitempty"`
+	Checksum    string    `json:"checksum,omitempty"`
+	AppliedAt   time.Time `json:"applied_at"`
+	DurationMS  int64     `json:"duration_ms,omitempty"`
+	AppliedBy   string    `json:"applied_by,omitempty"`
+	Hostname    string    `json:"hostname,omitempty"`
+	ToolVersion string    `json:"tool_version,omitempty"`
+	Transaction *bool     `json:"in_transaction,omitempty"`
+}
+
+// GetMigrationStatus joins the migration files against the schema migrations
+// table and returns the state of every known version.
+func GetMigrationStatus(cfg *Config) (MigrationStatuses, error) {
+	m, err := NewMigrator(cfg)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	defer m.Close()
+
+	return m.Status(context.Background())
+}
+
+func migrationStatuses(mFiles migrationFiles, applied []MigrationRecord) MigrationStatuses {
+	statuses := make(MigrationStatuses, 0, len(mFiles))
+	appliedMap := make(map[int64]time.Time)
+	for i := range applied {
+		appliedMap[applied[i].Version] = applied[i].AppliedAt
+	}
+
+	for version, filePath := range mFiles {
+		status := MigrationStatus{
+			Version: version,
+			Name:    getNameFromFileName(filepath.Base(filePath)),
+			File:    filePath,
+			State:   StatePending,
+		}
+		if createdAt, ok := appliedMap[version]; ok {
+			appliedAt := createdAt
+			status.AppliedAt = &appliedAt
+			status.State = StateApplied
+		}
+		statuses = append(statuses, status)
+	}
+
+	for i := range applied {
+		if _, ok := mFiles[applied[i].Version]; ok {
+			continue
+		}
+		appliedAt := applied[i].AppliedAt
+		statuses = append(statuses, MigrationStatus{
+			Version:   applied[i].Version,
+			AppliedAt: &appliedAt,
+			State:     StateFileMissing,
+		})
+	}
+
+	sort.Slice(
+		statuses,
+		func(i, j int) bool {
+			return statuses[i].Version < statuses[j].Version
+		},
+	)
+
+	return statuses
+}
+
+// getNameFromFileName returns the name portion of a migration file name, e.g.
+// `create_users` for `1600000000_create_users.no_txn.up.sql`.
+func getNameFromFileName(fileName string) string {
+	baseTokens := strings.Split(fileName, ".")
+	subTokens := strings.SplitN(baseTokens[0], "_", 2)
+	if len(subTokens) < 2 {
+		return ""
+	}
+	return subTokens[1]
+}
+
+// getAppliedMigrations returns the records of the schema migrations table.
+// The columns added after the table was created are read when they exist, so
+// a table not yet upgraded by ensureTableSchemaMigration is read without DDL.
+func getAppliedMigrations(ctx context.Context, q execer, cfg *Config) ([]MigrationRecord, error) {
+	rows, err := q.QueryContext(
+		ctx,
+		stmntAppliedSchemaMigrations,
+		cfg.Migration.Table.Schema,
+		cfg.Migration.Table.Name,
+	)
+	if err != nil {
+		return nil, NewError(err)
+	}
+	defer rows.Close()
+
+	appliedMigrations := make([]MigrationRecord, 0)
+	for rows.Next() {
+		var migration MigrationRecord
+		var checksum, fileName, name, appliedBy, hostname, toolVersion sql.NullString
+		var durationMS sql.NullInt64
+		var transaction sql.NullBool
+		err = rows.Scan(
+			&migration.Version,
+			&migration.AppliedAt,
+			&checksum,
+			&fileName,
+			&name,
+			&durationMS,
+			&appliedBy,
+			&hostname,
+			&toolVersion,
+			&transaction,
+		)
+		if err != nil {
+			return nil, NewError(err)
+		}
+		migration.Checksum = checksum.String
+		migration.FileName = fileName.String
+		migration.Name = name.String
+		migration.DurationMS = durationMS.Int64
+		migration.AppliedBy = appliedBy.String
+		migration.Hostname = hostname.String
+		migration.ToolVersion = toolVersion.String
+		if transaction.Valid {
+			migration.Transaction = &transaction.Bool
+		}
+		appliedMigrations = append(appliedMigrations, migration)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, NewError(err)
+	}
+
+	return appliedMigrations, nil
+}
+
+// Show returns the schema migrations table record of an applied version.
+func (m *Migrator) Show(ctx context.Context, version int64) (*MigrationRecord, error) {
+	var record *MigrationRecord
+	err := m.session(ctx, false, func(conn *sql.Conn) error {
+		exists, err := schemaMigrationsTableExists(ctx, conn, m.cfg)
+		if err != nil {
+			return NewError(err)
+		}
+		if !exists {
+			return NewError(fmt.Errorf("version: %v has not been applied", version))
+		}
+
+		applied, err := getAppliedMigrations(ctx, conn, m.cfg)
+		if err != nil {
+			return NewError(err)
+		}
+
+		for i := range applied {
+			if applied[i].Version == version {
+				record = &applied[i]
+				return nil
+			}
+		}
+		return NewError(fmt.Errorf("version: %v has not been applied", version))
+	})
+	if err != nil {
+		return nil, NewError(err)
+	}
+
+	return record, nil
+}
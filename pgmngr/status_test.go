@@ -0,0 +1,82 @@
+package pgmngr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetNameFromFileName(t *testing.T) {
+	require.Equal(t, "create_users", getNameFromFileName("1600000000_create_users.up.sql"))
+	require.Equal(t, "create_index", getNameFromFileName("1600000000_create_index.no_txn.up.sql"))
+	require.Equal(t, "", getNameFromFileName("1600000000.up.sql"))
+}
+
+func TestMigrationStatuses(t *testing.T) {
+	appliedAt := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	mFiles := migrationFiles{
+		1600000001: "migrations/1600000001_a.up.sql",
+		1600000003: "migrations/1600000003_c.up.sql",
+	}
+	applied := []MigrationRecord{
+		{Version: 1600000001, AppliedAt: appliedAt},
+		{Version: 1600000002, AppliedAt: appliedAt},
+	}
+
+	statuses := migrationStatuses(mFiles, applied)
+	require.Equal(t, 3, len(statuses))
+
+	require.Equal(t, int64(1600000001), statuses[0].Version)
+	require.Equal(t, "a", statuses[0].Name)
+	require.Equal(t, StateApplied, statuses[0].State)
+	require.Equal(t, appliedAt, *statuses[0].AppliedAt)
+
+	require.Equal(t, int64(1600000002), statuses[1].Version)
+	require.Equal(t, StateFileMissing, statuses[1].State)
+	require.Empty(t, statuses[1].File)
+
+	require.Equal(t, int64(1600000003), statuses[2].Version)
+	require.Equal(t, StatePending, statuses[2].State)
+	require.Nil(t, statuses[2].AppliedAt)
+
+	pending := statuses.Pending()
+	require.Equal(t, 1, len(pending))
+	require.Equal(t, int64(1600000003), pending[0].Version)
+}
+
+func TestMigrator_ShowLegacyTable(t *testing.T) {
+	ctx := context.Background()
+	m, tempDir := testMigrator(t, "legacy")
+
+	writeTestMigration(t, tempDir, 1600000001, "a", "SELECT 1;", "SELECT 1;")
+
+	// the table as created before checksums and run metadata were recorded
+	_, err := m.db.ExecContext(ctx, `
+CREATE TABLE public.schema_migrations (
+  schema_migration_version INT8 NOT NULL PRIMARY KEY,
+  created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL
+);
+INSERT INTO public.schema_migrations (schema_migration_version) VALUES (1600000001);
+`)
+	require.NoError(t, err)
+
+	record, err := m.Show(ctx, 1600000001)
+	require.NoError(t, err)
+	require.Equal(t, "", record.Checksum)
+	require.Nil(t, record.Transaction)
+
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(statuses))
+	require.Equal(t, StateApplied, statuses[0].State)
+
+	var columns int
+	err = m.db.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM information_schema.columns
+WHERE table_schema = 'public' AND table_name = 'schema_migrations'
+`).Scan(&columns)
+	require.NoError(t, err)
+	require.Equal(t, 2, columns)
+}
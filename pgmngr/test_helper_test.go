@@ -1,6 +1,9 @@
 package pgmngr
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -9,7 +12,18 @@ import (
 type testCliContext struct{}
 
 func (t *testCliContext) String(s string) string {
-	return configFile
+	if s == "config-file" {
+		return configFile
+	}
+	return ""
+}
+
+func (t *testCliContext) StringSlice(s string) []string {
+	return nil
+}
+
+func (t *testCliContext) IsSet(s string) bool {
+	return s == "config-file"
 }
 
 func testConfig(t *testing.T) *Config {
@@ -18,3 +32,49 @@ func testConfig(t *testing.T) *Config {
 	require.NoError(t, err)
 	return cfg
 }
+
+// testMigrator returns a Migrator of a new database, named after the test
+// database with the suffix, migrating a new temporary directory. Both are
+// removed once the test is done.
+func testMigrator(t *testing.T, suffix string) (*Migrator, string) {
+	tempDir, err := ioutil.TempDir("", "migrations_")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	cfg := testConfig(t)
+	cfg.Connection.Migration.Database += "_" + suffix
+	cfg.Migration.Directory = tempDir
+	err = CreateDatabase(*cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		err := DropDatabase(*cfg)
+		require.NoError(t, err)
+	})
+
+	m, err := NewMigrator(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		m.Close()
+	})
+
+	return m, tempDir
+}
+
+func testAppliedVersions(t *testing.T, cfg *Config) []int64 {
+	m, err := NewMigrator(cfg)
+	require.NoError(t, err)
+	defer m.Close()
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+
+	versions := make([]int64, 0)
+	for i := range statuses {
+		if statuses[i].State != StatePending {
+			versions = append(versions, statuses[i].Version)
+		}
+	}
+	return versions
+}
@@ -0,0 +1,153 @@
+package pgmngr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ParaServices/errgo"
+	"github.com/lib/pq"
+)
+
+// pqLockNotAvailable is the SQLSTATE of lock_not_available, raised when a
+// lock could not be acquired within the lock timeout.
+const pqLockNotAvailable = "55P03"
+
+// timeouts returns the lock and statement timeouts of a migration, the
+// directives of its file taking precedence over the config.
+func (m *Migrator) timeouts(pm PlannedMigration) (time.Duration, time.Duration, error) {
+	lockTimeout, err := parseConfigDuration("lock_timeout", m.cfg.Migration.LockTimeout)
+	if err != nil {
+		return 0, 0, NewError(err)
+	}
+	if pm.LockTimeout != 0 {
+		lockTimeout = pm.LockTimeout
+	}
+
+	statementTimeout, err := parseConfigDuration("statement_timeout", m.cfg.Migration.StatementTimeout)
+	if err != nil {
+		return 0, 0, NewError(err)
+	}
+	if pm.StatementTimeout != 0 {
+		statementTimeout = pm.StatementTimeout
+	}
+
+	return lockTimeout, statementTimeout, nil
+}
+
+func parseConfigDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, NewError(fmt.Errorf("invalid migration.%s: %v", name, err))
+	}
+	return d, nil
+}
+
+// setTimeouts sets the non-zero timeouts, for the current transaction only
+// when local is set.
+func setTimeouts(ctx context.Context, exec execer, local bool, lockTimeout, statementTimeout time.Duration) error {
+	settings := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"lock_timeout", lockTimeout},
+		{"statement_timeout", statementTimeout},
+	}
+	for _, setting := range settings {
+		if setting.value == 0 {
+			continue
+		}
+		_, err := exec.ExecContext(
+			ctx,
+			stmntSetConfig,
+			setting.name,
+			fmt.Sprintf("%dms", setting.value.Milliseconds()),
+			local,
+		)
+		if err != nil {
+			return NewError(err)
+		}
+	}
+	return nil
+}
+
+// resetTimeouts restores the session defaults of the timeouts set outside of
+// a transaction.
+func resetTimeouts(conn *sql.Conn, lockTimeout, statementTimeout time.Duration) {
+	ctx := context.Background()
+	if lockTimeout != 0 {
+		conn.ExecContext(ctx, "RESET lock_timeout")
+	}
+	if statementTimeout != 0 {
+		conn.ExecContext(ctx, "RESET statement_timeout")
+	}
+}
+
+func isLockNotAvailable(err error) bool {
+	switch e := err.(type) {
+	case *pq.Error:
+		return e.Code == pqLockNotAvailable
+	case *errgo.Error:
+		return e.PQError != nil && e.PQError.Error != nil &&
+			e.PQError.Code == pqLockNotAvailable
+	}
+	return false
+}
+
+// retryBackoff returns the time to wait before the given retry attempt,
+// doubling the backoff for every attempt up to maxBackoff.
+func retryBackoff(backoff, maxBackoff time.Duration, attempt int) time.Duration {
+	wait := backoff
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	if wait > maxBackoff {
+		return maxBackoff
+	}
+	return wait
+}
+
+// runWithRetry runs a migration, re-attempting it as configured when it
+// failed because a lock was not available. Migrations running outside of a
+// transaction are never retried, as they might have been partially applied.
+func (m *Migrator) runWithRetry(ctx context.Context, conn *sql.Conn, mType MigrationType, pm PlannedMigration) (MigrationResult, error) {
+	backoff, err := parseConfigDuration("retry.backoff", m.cfg.Migration.Retry.Backoff)
+	if err != nil {
+		return MigrationResult{PlannedMigration: pm, Type: mType}, NewError(err)
+	}
+	maxBackoff, err := parseConfigDuration("retry.max_backoff", m.cfg.Migration.Retry.MaxBackoff)
+	if err != nil {
+		return MigrationResult{PlannedMigration: pm, Type: mType}, NewError(err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := m.run(ctx, conn, mType, pm)
+		if err == nil {
+			return result, nil
+		}
+		if !pm.Transaction || !isLockNotAvailable(err) || attempt > m.cfg.Migration.Retry.Attempts {
+			return result, err
+		}
+
+		wait := retryBackoff(backoff, maxBackoff, attempt)
+		m.notify(MigrationEvent{
+			Kind:    EventRetrying,
+			Version: pm.Version,
+			File:    pm.File,
+			Message: fmt.Sprintf("lock not available, retrying in %v (attempt %v of %v)", wait, attempt, m.cfg.Migration.Retry.Attempts),
+		})
+
+		select {
+		case <-ctx.Done():
+			return result, NewError(ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
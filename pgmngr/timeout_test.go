@@ -0,0 +1,53 @@
+package pgmngr
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrator_timeouts(t *testing.T) {
+	cfg := &Config{}
+	cfg.Migration.LockTimeout = "2s"
+	cfg.Migration.StatementTimeout = "30s"
+	m := &Migrator{cfg: cfg}
+
+	lockTimeout, statementTimeout, err := m.timeouts(PlannedMigration{})
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, lockTimeout)
+	require.Equal(t, 30*time.Second, statementTimeout)
+
+	lockTimeout, statementTimeout, err = m.timeouts(PlannedMigration{LockTimeout: 5 * time.Second})
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, lockTimeout)
+	require.Equal(t, 30*time.Second, statementTimeout)
+
+	cfg.Migration.LockTimeout = "forever"
+	_, _, err = m.timeouts(PlannedMigration{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid migration.lock_timeout")
+}
+
+func TestRetryBackoff(t *testing.T) {
+	require.Equal(t, time.Second, retryBackoff(time.Second, 30*time.Second, 1))
+	require.Equal(t, 2*time.Second, retryBackoff(time.Second, 30*time.Second, 2))
+	require.Equal(t, 8*time.Second, retryBackoff(time.Second, 30*time.Second, 4))
+	require.Equal(t, 30*time.Second, retryBackoff(time.Second, 30*time.Second, 10))
+	require.Equal(t, 5*time.Second, retryBackoff(10*time.Second, 5*time.Second, 1))
+}
+
+func TestIsLockNotAvailable(t *testing.T) {
+	lockErr := &pq.Error{Code: pqLockNotAvailable}
+	require.True(t, isLockNotAvailable(lockErr))
+	require.True(t, isLockNotAvailable(NewError(lockErr)))
+	require.True(t, isLockNotAvailable(statementError("1_foo.up.sql", statement{Line: 1}, lockErr)))
+	require.True(t, isLockNotAvailable(goMigrationError("1_foo.up.go", lockErr)))
+	require.True(t, isLockNotAvailable(goMigrationError("1_foo.up.go", fmt.Errorf("backfill: %w", lockErr))))
+	require.True(t, isLockNotAvailable(goMigrationError("1_foo.up.go", NewError(lockErr))))
+
+	require.False(t, isLockNotAvailable(&pq.Error{Code: "42P01"}))
+	require.False(t, isLockNotAvailable(NewError(fmt.Errorf("lock not available"))))
+}